@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+// Maximum age of a timestamp before raising warning/critical
+type AgeThresholds struct {
+	warning, critical time.Duration
+}
+
+func (a AgeThresholds) String() string {
+	return fmt.Sprintf("%s:%s", a.warning, a.critical)
+}
+
+// Layouts tried for timestamp strings when --key-age-layout isn't given
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// Epoch timestamps from this many seconds on are taken to be milliseconds.
+// 1e11 seconds is over 3000 years away, 1e11 milliseconds is 1973.
+var epochMillis = big.NewRat(1e11, 1)
+
+// Parse an age threshold given as seconds (eg. 300) or a Go duration
+// (eg. 5m, 1h30m)
+func parseAge(str string) (time.Duration, error) {
+
+	if secs, err := strconv.ParseFloat(str, 64); err == nil {
+		return seconds(secs), nil
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, errors.New(
+			fmt.Sprintf("Age '%s' is not a number of seconds or a duration (eg. 5m)", str),
+		)
+	}
+
+	return d, nil
+}
+
+// The time of a JSON timestamp. Strings are parsed with layout if given,
+// otherwise as RFC3339, RFC1123 or epoch seconds. Numbers are epoch
+// seconds, or milliseconds if large enough.
+func parseTimestamp(val interface{}, layout string) (time.Time, error) {
+
+	switch v := val.(type) {
+
+	case json.Number:
+		return epochTime(v)
+
+	case string:
+		if layout != "" {
+			return time.Parse(layout, v)
+		}
+
+		for _, l := range timestampLayouts {
+			if t, err := time.Parse(l, v); err == nil {
+				return t, nil
+			}
+		}
+
+		if n, err := parseNumber(strings.TrimSpace(v)); err == nil {
+			return epochTime(n)
+		}
+	}
+
+	return time.Time{}, errors.New(
+		fmt.Sprintf("value %s is not a timestamp", jsonLiteral(val)),
+	)
+}
+
+// Convert epoch seconds or milliseconds to a time, keeping the fraction
+func epochTime(n json.Number) (time.Time, error) {
+
+	r, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		return time.Time{}, errors.New(fmt.Sprintf("value '%s' is not a timestamp", n))
+	}
+
+	unit := big.NewRat(int64(time.Second), 1)
+	if r.Cmp(epochMillis) >= 0 {
+		unit = big.NewRat(int64(time.Millisecond), 1)
+	}
+
+	r.Mul(r, unit)
+	nanos := new(big.Int).Quo(r.Num(), r.Denom())
+	if !nanos.IsInt64() {
+		return time.Time{}, errors.New(fmt.Sprintf("value '%s' is out of range for a timestamp", n))
+	}
+
+	return time.Unix(0, nanos.Int64()), nil
+}
+
+// Check the age of a timestamp against the thresholds
+func checkAge(key string, val interface{}, ages AgeThresholds, now time.Time) error {
+
+	t, err := parseTimestamp(val, opts.KeyAgeLayout)
+	if err != nil {
+		return valueError{fmt.Sprintf("Key '%s' %s", key, err)}
+	}
+
+	age := now.Sub(t)
+
+	switch {
+	case ages.critical > 0 && age > ages.critical:
+		return statusError(nagiosplugin.CRITICAL,
+			fmt.Sprintf("Key '%s' is %s old, more than the critical threshold %s",
+				key, roundAge(age), ages.critical))
+
+	case ages.warning > 0 && age > ages.warning:
+		return statusError(nagiosplugin.WARNING,
+			fmt.Sprintf("Key '%s' is %s old, more than the warning threshold %s",
+				key, roundAge(age), ages.warning))
+	}
+
+	return nil
+}
+
+// Ages are shown in whole seconds, timestamps are rarely more precise
+func roundAge(age time.Duration) time.Duration {
+	return age.Truncate(time.Second)
+}
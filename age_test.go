@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+/*
+ * Data models to hold timestamp test cases
+ */
+
+type TestParseAgeCase struct {
+	param string
+	age   time.Duration
+	valid bool
+}
+
+type TestTimestampCase struct {
+	value  interface{}
+	layout string
+	time   time.Time
+	valid  bool
+}
+
+type TestKeyAgeCase struct {
+	param  string
+	send   []byte
+	status nagiosplugin.Status
+	errStr string
+}
+
+/*
+ * Tests for primary functions
+ */
+
+func Test_parseAge(t *testing.T) {
+
+	cases := []TestParseAgeCase{
+		{"300", 5 * time.Minute, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{"90m", 90 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"soon", 0, false},
+	}
+
+	for _, c := range cases {
+		age, err := parseAge(c.param)
+		expect(t, c.valid, err == nil)
+		expect(t, c.age, age)
+	}
+}
+
+func Test_parseTimestamp(t *testing.T) {
+
+	when := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	cases := []TestTimestampCase{
+		{"2023-11-14T22:13:20Z", "", when, true},
+		{"2023-11-14T23:13:20+01:00", "", when, true},
+		{"Tue, 14 Nov 2023 22:13:20 UTC", "", when, true},
+		{"Tue, 14 Nov 2023 23:13:20 +0100", "", when, true},
+		{json.Number("1700000000"), "", when, true},
+		{json.Number("1700000000000"), "", when, true},
+		{json.Number("1700000000.5"), "", when.Add(500 * time.Millisecond), true},
+		{"1700000000", "", when, true},
+		{"2023-11-14 22:13:20", "2006-01-02 15:04:05", when, true},
+		{"2023-11-14 22:13:20", "", time.Time{}, false},
+		{"yesterday", "", time.Time{}, false},
+		{true, "", time.Time{}, false},
+		{nil, "", time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		got, err := parseTimestamp(c.value, c.layout)
+		expect(t, c.valid, err == nil)
+		expect(t, true, c.time.Equal(got))
+	}
+}
+
+func Test_checkJson_Age(t *testing.T) {
+
+	now := time.Now()
+	ago := func(d time.Duration) string {
+		return now.Add(-d).UTC().Format(time.RFC3339Nano)
+	}
+
+	cases := []TestKeyAgeCase{
+		{"last_success:1h:6h",
+			[]byte(fmt.Sprintf(`{"last_success":"%s"}`, ago(10*time.Minute))),
+			nagiosplugin.OK, ""},
+
+		{"last_success:1h:6h",
+			[]byte(fmt.Sprintf(`{"last_success":"%s"}`, ago(2*time.Hour))),
+			nagiosplugin.WARNING,
+			"Key 'last_success' is 2h0m0s old, more than the warning threshold 1h0m0s"},
+
+		{"last_success:3600:21600",
+			[]byte(fmt.Sprintf(`{"last_success":%d}`,
+				now.Add(-7*time.Hour).UnixNano()/int64(time.Millisecond))),
+			nagiosplugin.CRITICAL,
+			"Key 'last_success' is 7h0m0s old, more than the critical threshold 6h0m0s"},
+
+		{"all(jobs[*].updated):1h:6h",
+			[]byte(fmt.Sprintf(`{"jobs":[{"updated":%d},{"updated":"%s"}]}`,
+				now.Add(-time.Minute).UnixNano()/int64(time.Millisecond), ago(time.Minute))),
+			nagiosplugin.OK, ""},
+
+		{"all(jobs[*].updated):1h:6h",
+			[]byte(fmt.Sprintf(`{"jobs":[{"updated":"%s"},{"updated":"%s"}]}`,
+				ago(time.Minute), ago(2*time.Hour))),
+			nagiosplugin.WARNING,
+			"Key 'all(jobs[*].updated)' failed for 1 of 2 values (jobs[1].updated): " +
+				"Key 'jobs[1].updated' is 2h0m0s old, more than the warning threshold 1h0m0s"},
+
+		{"all(jobs[*].updated):1h:6h",
+			[]byte(fmt.Sprintf(`{"jobs":[{"updated":"%s"},{"updated":"%s"}]}`,
+				ago(2*time.Hour), ago(7*time.Hour))),
+			nagiosplugin.CRITICAL,
+			"Key 'all(jobs[*].updated)' failed for 2 of 2 values (jobs[0].updated, jobs[1].updated): " +
+				"Key 'jobs[1].updated' is 7h0m0s old, more than the critical threshold 6h0m0s"},
+
+		{"atleast(2, jobs[*].updated):1h:6h",
+			[]byte(fmt.Sprintf(`{"jobs":[{"updated":"%s"},{"updated":"%s"}]}`,
+				ago(time.Minute), ago(2*time.Hour))),
+			nagiosplugin.WARNING,
+			"Key 'atleast(2, jobs[*].updated)' passed for 1 of 2 values, expected at least 2 (failed: jobs[1].updated)"},
+
+		{"updated_at:1h:6h",
+			[]byte(`{"updated_at":"not a time"}`),
+			nagiosplugin.CRITICAL,
+			"Key 'updated_at' value \"not a time\" is not a timestamp"},
+
+		{"!updated_at:1h:6h",
+			[]byte(`{"updated_at":"not a time"}`),
+			nagiosplugin.CRITICAL,
+			"Key 'updated_at' value \"not a time\" is not a timestamp"},
+	}
+
+	for _, c := range cases {
+		Tests["keys"] = false
+		JsonTests = JsonTests[:0]
+
+		// eg. opts.FlagKeyAge("last_success:1h:6h") simulates --key-age=last_success:1h:6h
+		opts.FlagKeyAge(c.param)
+		expect(t, true, Tests["keys"])
+
+		doc, err := decodeJson(c.send)
+		check(err)
+
+		match, err := checkJson(doc, JsonTests[0])
+		expect(t, true, match)
+
+		if c.status == nagiosplugin.OK {
+			expect(t, nil, err)
+		} else {
+			expect(t, c.status, failStatus(err))
+			expect(t, c.errStr, err.Error())
+		}
+	}
+
+	JsonTests = JsonTests[:0]
+}
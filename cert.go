@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+// Check the certificate chain presented by the server. Reports the
+// certificate expiring soonest, and chain problems such as an unknown CA or
+// the wrong host name (which --insecure would otherwise hide).
+func checkCertificate(state *tls.ConnectionState, host string, now time.Time) (bool, error) {
+
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return false, statusError(nagiosplugin.UNKNOWN,
+			"No server certificate to check, the request did not use TLS")
+	}
+
+	leaf := state.PeerCertificates[0]
+	soonest := soonestExpiry(state.PeerCertificates)
+
+	days := daysLeft(soonest, now)
+	expires := soonest.NotAfter.Format("2006-01-02 15:04 MST")
+
+	desc := fmt.Sprintf("Certificate '%s' issued by '%s'", leaf.Subject, leaf.Issuer)
+	if soonest != leaf {
+		desc = fmt.Sprintf("Certificate '%s' chain certificate '%s' issued by '%s'",
+			leaf.Subject, soonest.Subject, soonest.Issuer)
+	}
+
+	switch {
+	case now.After(soonest.NotAfter):
+		return false, errors.New(
+			fmt.Sprintf("%s expired on %s", desc, expires))
+
+	case days < CertTest["critical"]:
+		return false, errors.New(
+			fmt.Sprintf("%s expires in %d day(s) on %s", desc, days, expires))
+	}
+
+	// Chain problems are worse than an upcoming expiry
+	err := verifyChain(state.PeerCertificates, host, now)
+	if err != nil {
+		return false, errors.New(
+			fmt.Sprintf("Certificate '%s' issued by '%s' chain problem: %s",
+				leaf.Subject, leaf.Issuer, err))
+	}
+
+	if days < CertTest["warning"] {
+		return false, statusError(nagiosplugin.WARNING,
+			fmt.Sprintf("%s expires in %d day(s) on %s", desc, days, expires))
+	}
+
+	return true, nil // All tests passed, no errors
+}
+
+// Describe the certificate chain for the plugin output
+func describeCertificate(state *tls.ConnectionState, now time.Time) string {
+
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "No server certificate"
+	}
+
+	leaf := state.PeerCertificates[0]
+
+	return fmt.Sprintf("Certificate '%s' issued by '%s' expires in %d day(s) on %s",
+		leaf.Subject, leaf.Issuer, daysLeft(leaf, now), leaf.NotAfter.Format("2006-01-02 15:04 MST"))
+}
+
+// The chain is only as good as its first certificate to expire
+func soonestExpiry(chain []*x509.Certificate) *x509.Certificate {
+
+	soonest := chain[0]
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(soonest.NotAfter) {
+			soonest = cert
+		}
+	}
+
+	return soonest
+}
+
+// Whole days until a certificate expires
+func daysLeft(cert *x509.Certificate, now time.Time) int {
+	return int(cert.NotAfter.Sub(now).Hours() / 24)
+}
+
+// Verify the chain against the trusted CAs (--ca-file or the system pool)
+// and the host name (--sni or the host requested)
+func verifyChain(chain []*x509.Certificate, host string, now time.Time) error {
+
+	config, err := tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	if config.ServerName != "" {
+		host = config.ServerName
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         config.RootCAs,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+/*
+ * Data models to hold certificate test cases
+ */
+
+type TestCertificateCase struct {
+	param  string
+	chain  []*x509.Certificate
+	host   string
+	status nagiosplugin.Status
+	errStr string
+}
+
+/*
+ * Tests for primary functions
+ */
+
+func Test_checkCertificate(t *testing.T) {
+
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	days := func(d int) time.Time { return now.Add(time.Duration(d) * 24 * time.Hour) }
+
+	ca, caKey := testCert("Test CA", days(365), nil, nil)
+	leaf, _ := testCert("localhost", days(45), ca, caKey)
+	soon, _ := testCert("localhost", days(10), ca, caKey)
+	urgent, _ := testCert("localhost", days(3), ca, caKey)
+	expired, _ := testCert("localhost", days(-1), ca, caKey)
+	oldCa, oldCaKey := testCert("Old CA", days(5), nil, nil)
+	oldLeaf, _ := testCert("localhost", days(90), oldCa, oldCaKey)
+
+	// Trust the test CAs
+	dir, err := ioutil.TempDir("", "check-json")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	check(ioutil.WriteFile(caFile, append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: oldCa.Raw})...), 0600))
+	httpOpts.CaFile = caFile
+
+	cases := []TestCertificateCase{
+		{"30,14", []*x509.Certificate{leaf, ca}, "localhost",
+			nagiosplugin.OK, ""},
+
+		{"30,7", []*x509.Certificate{soon, ca}, "localhost",
+			nagiosplugin.WARNING,
+			"Certificate 'CN=localhost' issued by 'CN=Test CA' expires in 10 day(s) on 2026-06-11 12:00 UTC"},
+
+		{"30,5", []*x509.Certificate{urgent, ca}, "localhost",
+			nagiosplugin.CRITICAL,
+			"Certificate 'CN=localhost' issued by 'CN=Test CA' expires in 3 day(s) on 2026-06-04 12:00 UTC"},
+
+		{"30", []*x509.Certificate{expired, ca}, "localhost",
+			nagiosplugin.CRITICAL,
+			"Certificate 'CN=localhost' issued by 'CN=Test CA' expired on 2026-05-31 12:00 UTC"},
+
+		// Intermediate expires before the leaf
+		{"30,2", []*x509.Certificate{oldLeaf, oldCa}, "localhost",
+			nagiosplugin.WARNING,
+			"Certificate 'CN=localhost' chain certificate 'CN=Old CA' issued by 'CN=Old CA' " +
+				"expires in 5 day(s) on 2026-06-06 12:00 UTC"},
+
+		// Chain problems
+		{"30,14", []*x509.Certificate{leaf, ca}, "wrong.example.net",
+			nagiosplugin.CRITICAL,
+			"Certificate 'CN=localhost' issued by 'CN=Test CA' chain problem: x509: certificate is " +
+				"valid for localhost, not wrong.example.net"},
+
+		{"30,14", []*x509.Certificate{}, "localhost",
+			nagiosplugin.UNKNOWN,
+			"No server certificate to check, the request did not use TLS"},
+	}
+
+	for _, c := range cases {
+		// eg. opts.FlagCertificate("30,14") simulates --certificate=30,14
+		opts.FlagCertificate(c.param)
+
+		// Test the flag that drives the test
+		expect(t, Tests["certificate"], true)
+
+		state := &tls.ConnectionState{PeerCertificates: c.chain}
+		match, err := checkCertificate(state, c.host, now)
+		expect(t, c.status == nagiosplugin.OK, match)
+
+		if c.status != nagiosplugin.OK {
+			expect(t, c.status, failStatus(err))
+			expect(t, c.errStr, err.Error())
+		}
+	}
+
+	// Untrusted without the CA file
+	httpOpts.CaFile = ""
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}}
+	match, err := checkCertificate(state, "localhost", now)
+	expect(t, false, match)
+	expectErr(t, err)
+}
+
+func Test_describeCertificate(t *testing.T) {
+
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	ca, caKey := testCert("Test CA", now.Add(365*24*time.Hour), nil, nil)
+	leaf, _ := testCert("localhost", now.Add(45*24*time.Hour), ca, caKey)
+
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}}
+	expect(t,
+		"Certificate 'CN=localhost' issued by 'CN=Test CA' expires in 45 day(s) on 2026-07-16 12:00 UTC",
+		describeCertificate(state, now))
+}
+
+// Generate a certificate for the named host, signed by parent or self
+// signed (as a CA) if parent is nil
+func testCert(
+	name string,
+	notAfter time.Time,
+	parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	check(err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	check(err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-5 * 365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	check(err)
+
+	cert, err := x509.ParseCertificate(der)
+	check(err)
+
+	return cert, key
+}
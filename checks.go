@@ -1,31 +1,81 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/fractalcat/nagiosplugin"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 type Options struct {
 	FlagStatus func(int) `long:"status" short:"s" description:"Checks the numerical HTTP return status (eg. 200)"`
 
+	FlagWarning func(float64) `long:"warning" short:"w" description:"Response time to result in warning status (seconds)"`
+
+	FlagCritical func(float64) `long:"critical" short:"c" description:"Response time to result in critical status (seconds)"`
+
+	FlagCertificate func(string) `long:"certificate" short:"C" description:"Minimum days the server certificate chain must be valid for (warn_days[,crit_days])"`
+
 	FlagPageSize func(string) `long:"page-size" short:"m" description:"Checks response content length is in the given range (format: min:max)"`
 
 	FlagHeaders func(string) `long:"header-equals" short:"d" description:"Key=value checks for HTTP response headers (key:value)"`
 
 	FlagRegexp func(string) `long:"regexp" short:"r" description:"Checks the response body for a string using a regular expression."`
 
-	FlagKeyExists func(string) `long:"key-exists" short:"e" description:"Checks existence of these keys from JSON response"`
+	FlagSchema func(string) `long:"schema" description:"Validate the JSON response against a JSON Schema file (draft 4 to 2020-12, draft 2020-12 if no $schema is given)"`
+
+	FlagKeyExists func(string) `long:"key-exists" short:"e" description:"Checks existence of a key path (eg. checks.db.status, items[0].id, ..status) in JSON response"`
+
+	FlagKeyAbsent func(string) `long:"key-absent" description:"Checks a key path is not in JSON response (eg. error)"`
 
 	FlagKeyEquals func(string) `long:"key-equals" short:"q" description:"A regex to check the value of specific key values from JSON response"`
 
+	FlagKeyNotEquals func(string) `long:"key-not-equals" description:"A regex the value of a JSON key must not match (eg. state:degraded). Any key test can be negated by starting its key path with '!'"`
+
+	AnchorEquals bool `long:"anchor-equals" description:"Anchor --key-equals and --key-not-equals regexps so they must match the whole value (eg. ok doesn't match broken)" default:"false"`
+
+	FlagKeyIs func(string) `long:"key-is" description:"Checks a JSON value is exactly this (path:value). Numbers compare by value, eg. 5 is 5.0. Numbers, true, false and null only match those types, quote strings like them (eg. version:'\"3\"')"`
+
+	FlagKeyIsNocase func(string) `long:"key-is-nocase" description:"As --key-is, ignoring case"`
+
+	FlagKeyIn func(string) `long:"key-in" description:"Checks a JSON value is one of a list (path:a,b,c)"`
+
+	FlagKeyInNocase func(string) `long:"key-in-nocase" description:"As --key-in, ignoring case"`
+
+	StrictEquals bool `long:"strict-equals" description:"Match --key-equals regexps against whole values as written in JSON, so strings include their quotes and \"true\" doesn't match true. Implies --anchor-equals" default:"false"`
+
+	FlagKeyType func(string) `long:"key-type" description:"Checks the type of a JSON value (path:string|number|integer|boolean|null|array|object)"`
+
 	FlagKeyLte func(string) `long:"key-lte" short:"l" description:"Check the returned value is less than this for a JSON key"`
 
 	FlagKeyGte func(string) `long:"key-gte" short:"g" description:"Check the returned value is greater than this for a JSON key"`
 
+	FlagKeyWarning func(string) `long:"key-warning" description:"WARNING if a JSON key's value is outside a Nagios range (eg. queue.depth:100, ~:10, @10:20)"`
+
+	FlagKeyCritical func(string) `long:"key-critical" description:"CRITICAL if a JSON key's value is outside a Nagios range (eg. queue.depth:500)"`
+
+	FlagKeyLength func(string) `long:"key-length" description:"CRITICAL if the length of a JSON array, object or string is outside min and max (path:min:max, eg. nodes:3: or errors::0)"`
+
+	FlagKeyLengthWarning func(string) `long:"key-length-warning" description:"WARNING if the length of a JSON array, object or string is outside a Nagios range (eg. nodes:5:)"`
+
+	FlagKeyLengthCritical func(string) `long:"key-length-critical" description:"CRITICAL if the length of a JSON array, object or string is outside a Nagios range (eg. nodes:3:)"`
+
+	FlagKeyAge func(string) `long:"key-age" description:"WARNING/CRITICAL if a JSON timestamp is older than a number of seconds or a duration (path:warn:crit, eg. last_success:1h:6h)"`
+
+	KeyAgeLayout string `long:"key-age-layout" description:"Go time layout of --key-age timestamps (eg. \"2006-01-02 15:04:05\"). Default: RFC3339, RFC1123 or epoch seconds/milliseconds"`
+
+	ShowPassed bool `long:"show-passed" description:"List passed tests as well as failed ones in the long output" default:"false"`
+
 	Verbose bool `long:"verbose" short:"v" description:"Display extra details (eg. response bodies) for debugging" default:"false"`
 }
 
@@ -37,9 +87,19 @@ var Tests = make(map[string]bool)
 // A slice of reasons behind failed checks.
 var FailReasons = make([]error, 0)
 
+// Descriptions of the checks that passed.
+var PassReasons = make([]string, 0)
+
 // Test the responses numeric status (eg. 200)
 var StatusTest int
 
+// Warning/critical thresholds for the response time, in seconds
+var TimeTest = make(map[string]float64)
+
+// Minimum days the certificate chain must be valid for before raising
+// warning/critical
+var CertTest = map[string]int{"warning": 0, "critical": 0}
+
 // Min/max size for response Content-Length
 var PageSizeTest = map[string]int64{"min": 0, "max": 0}
 
@@ -49,6 +109,10 @@ var HeaderTests = make(map[string]string)
 // Test the response body using a regex match
 var RegexpTest *regexp.Regexp
 
+// JSON Schema the response body must match, and the file it came from
+var SchemaTest *jsonschema.Schema
+var SchemaFile string
+
 // Tests on the response JSON body.
 // Each test has an operator (eg equals) and a value (eg. "success")
 var JsonTests = make([]JsonTest, 0)
@@ -61,16 +125,49 @@ func init() {
 		StatusTest = code
 	}
 
+	opts.FlagWarning = func(secs float64) {
+		Tests["time"] = true
+
+		TimeTest["warning"] = secs
+	}
+
+	opts.FlagCritical = func(secs float64) {
+		Tests["time"] = true
+
+		TimeTest["critical"] = secs
+	}
+
+	opts.FlagCertificate = func(str string) {
+		Tests["certificate"] = true
+
+		days := strings.SplitN(str, ",", 2)
+		for i, state := range []string{"warning", "critical"} {
+			if i >= len(days) {
+				CertTest[state] = 0
+				continue
+			}
+
+			d, err := strconv.Atoi(strings.TrimSpace(days[i]))
+			if err != nil || d < 0 {
+				nagiosplugin.Exit(
+					nagiosplugin.UNKNOWN,
+					fmt.Sprintf("Certificate %s days '%s' is not a whole number", state, days[i]),
+				)
+			}
+			CertTest[state] = d
+		}
+	}
+
 	opts.FlagPageSize = func(str string) {
 		Tests["page-size"] = true
 
 		s, err := parseFlagPair("page-size", str)
-		check(err)
+		checkArg(err)
 
 		min, err := strconv.ParseInt(s[0], 10, 0)
 		if err != nil {
 			nagiosplugin.Exit(
-				nagiosplugin.CRITICAL,
+				nagiosplugin.UNKNOWN,
 				fmt.Sprintf("Page size min '%s' parameter is not an integer", s[0]),
 			)
 		}
@@ -79,7 +176,7 @@ func init() {
 		max, err := strconv.ParseInt(s[1], 10, 0)
 		if err != nil {
 			nagiosplugin.Exit(
-				nagiosplugin.CRITICAL,
+				nagiosplugin.UNKNOWN,
 				fmt.Sprintf("Page size max '%s' parameter is not an integer", s[1]),
 			)
 		}
@@ -87,8 +184,8 @@ func init() {
 
 		if max < min {
 			nagiosplugin.Exit(
-				nagiosplugin.CRITICAL,
-				fmt.Sprintf("Page size range must in format max:min"),
+				nagiosplugin.UNKNOWN,
+				fmt.Sprintf("Page size range must be in format min:max"),
 			)
 		}
 
@@ -98,7 +195,7 @@ func init() {
 		Tests["headers"] = true
 
 		s, err := parseFlagPair("header-equals", str)
-		check(err)
+		checkArg(err)
 
 		HeaderTests[s[0]] = s[1]
 	}
@@ -111,60 +208,313 @@ func init() {
 
 		if err != nil {
 			nagiosplugin.Exit(
-				nagiosplugin.CRITICAL,
+				nagiosplugin.UNKNOWN,
 				fmt.Sprintf("String '%s' not a valid regexp: %s", str, err),
 			)
 		}
 	}
 
+	opts.FlagSchema = func(str string) {
+		Tests["schema"] = true
+
+		var err error
+		SchemaTest, err = jsonschema.NewCompiler().Compile(str)
+		SchemaFile = str
+
+		if err != nil {
+			nagiosplugin.Exit(
+				nagiosplugin.UNKNOWN,
+				oneLine(fmt.Sprintf("Schema '%s' not valid: %s", str, err)),
+			)
+		}
+	}
+
 	// JSON keys to test if they exist
 	opts.FlagKeyExists = func(str string) {
-		Tests["keys"] = true
-		JsonTests = append(JsonTests, JsonTest{str, "", "exists"})
+		addJsonTest(str, "", "exists")
 	}
 
-	opts.FlagKeyEquals = func(str string) {
-		Tests["keys"] = true
+	opts.FlagKeyAbsent = func(str string) {
+		addJsonTest(str, "", "!exists")
+	}
 
+	opts.FlagKeyEquals = func(str string) {
 		s, err := parseFlagPair("key-equals", str)
-		check(err)
-		JsonTests = append(JsonTests, JsonTest{s[0], s[1], "equals"})
+		checkArg(err)
+		addJsonTest(s[0], s[1], "equals")
 	}
 
-	opts.FlagKeyLte = func(str string) {
-		Tests["keys"] = true
+	opts.FlagKeyNotEquals = func(str string) {
+		s, err := parseFlagPair("key-not-equals", str)
+		checkArg(err)
+		addJsonTest(s[0], s[1], "!equals")
+	}
 
+	opts.FlagKeyIs = func(str string) {
+		s, err := parseFlagPair("key-is", str)
+		checkArg(err)
+		addJsonTest(s[0], s[1], "is")
+	}
+
+	opts.FlagKeyIsNocase = func(str string) {
+		s, err := parseFlagPair("key-is-nocase", str)
+		checkArg(err)
+		addJsonTest(s[0], s[1], "is-nocase")
+	}
+
+	opts.FlagKeyIn = func(str string) {
+		s, err := parseFlagPair("key-in", str)
+		checkArg(err)
+		addJsonTest(s[0], strings.Split(s[1], ","), "in")
+	}
+
+	opts.FlagKeyInNocase = func(str string) {
+		s, err := parseFlagPair("key-in-nocase", str)
+		checkArg(err)
+		addJsonTest(s[0], strings.Split(s[1], ","), "in-nocase")
+	}
+
+	opts.FlagKeyType = func(str string) {
+		s, err := parseFlagPair("key-type", str)
+		checkArg(err)
+
+		if !jsonTypes[s[1]] {
+			nagiosplugin.Exit(
+				nagiosplugin.UNKNOWN,
+				fmt.Sprintf("Key type '%s' is not one of string, number, integer, boolean, null, array or object", s[1]),
+			)
+		}
+
+		addJsonTest(s[0], s[1], "type")
+	}
+
+	opts.FlagKeyLte = func(str string) {
 		s, err := parseFlagPair("key-lte", str)
-		check(err)
+		checkArg(err)
 
-		v, err := strconv.ParseFloat(s[1], 64)
+		v, err := parseNumber(s[1])
 		if err != nil {
 			nagiosplugin.Exit(
-				nagiosplugin.CRITICAL,
-				fmt.Sprintf("Key '%s' parameter is not an integer", s[1]),
+				nagiosplugin.UNKNOWN,
+				fmt.Sprintf("Key '%s' parameter is not a number", s[1]),
 			)
 		}
 
-		JsonTests = append(JsonTests, JsonTest{s[0], v, "lte"})
+		addJsonTest(s[0], v, "lte")
 	}
 
 	opts.FlagKeyGte = func(str string) {
-		Tests["keys"] = true
-
 		s, err := parseFlagPair("key-gte", str)
-		check(err)
+		checkArg(err)
 
-		v, err := strconv.ParseFloat(s[1], 64)
+		v, err := parseNumber(s[1])
 		if err != nil {
 			nagiosplugin.Exit(
-				nagiosplugin.CRITICAL,
-				fmt.Sprintf("Key '%s' parameter is not an integer", s[1]),
+				nagiosplugin.UNKNOWN,
+				fmt.Sprintf("Key '%s' parameter is not a number", s[1]),
+			)
+		}
+
+		addJsonTest(s[0], v, "gte")
+	}
+
+	opts.FlagKeyWarning = func(str string) {
+		s, err := parseFlagPair("key-warning", str)
+		checkArg(err)
+		validateRange(s[1])
+
+		addJsonTest(s[0], s[1], "warning")
+	}
+
+	opts.FlagKeyCritical = func(str string) {
+		s, err := parseFlagPair("key-critical", str)
+		checkArg(err)
+		validateRange(s[1])
+
+		addJsonTest(s[0], s[1], "critical")
+	}
+
+	opts.FlagKeyLength = func(str string) {
+		s, err := parseFlagPair("key-length", str)
+		checkArg(err)
+
+		rng, err := lengthRange(s[1])
+		checkArg(err)
+
+		addJsonTest(s[0], rng, "length-critical")
+	}
+
+	opts.FlagKeyLengthWarning = func(str string) {
+		s, err := parseFlagPair("key-length-warning", str)
+		checkArg(err)
+		validateRange(s[1])
+
+		addJsonTest(s[0], s[1], "length-warning")
+	}
+
+	opts.FlagKeyLengthCritical = func(str string) {
+		s, err := parseFlagPair("key-length-critical", str)
+		checkArg(err)
+		validateRange(s[1])
+
+		addJsonTest(s[0], s[1], "length-critical")
+	}
+
+	opts.FlagKeyAge = func(str string) {
+		s, err := parseFlagPair("key-age", str)
+		checkArg(err)
+
+		limits := strings.Split(s[1], flagSeperator)
+		if len(limits) != 2 {
+			nagiosplugin.Exit(
+				nagiosplugin.UNKNOWN,
+				fmt.Sprintf("Key age '%s' is not in the format path:warn:crit", str),
 			)
 		}
 
-		JsonTests = append(JsonTests, JsonTest{s[0], v, "gte"})
+		var ages AgeThresholds
+		ages.warning, err = parseAge(limits[0])
+		checkArg(err)
+		ages.critical, err = parseAge(limits[1])
+		checkArg(err)
+
+		addJsonTest(s[0], ages, "age")
+	}
+
+}
+
+// Add a test of a JSON key. A '!' in front of the key path negates the
+// test, eg. --key-type='!error:null'
+func addJsonTest(key string, value interface{}, operator string) {
+	Tests["keys"] = true
+
+	if strings.HasPrefix(key, "!") {
+		key = key[1:]
+		operator = negateOperator(operator)
+	}
+
+	validatePath(key)
+	JsonTests = append(JsonTests, JsonTest{key, value, operator})
+}
+
+// Negate a JSON test operator. Negated operators start with '!'.
+func negateOperator(operator string) string {
+	if strings.HasPrefix(operator, "!") {
+		return operator[1:]
 	}
+	return "!" + operator
+}
+
+// Exit UNKNOWN if a JSON key path given as a flag can't be parsed
+func validatePath(str string) {
+	_, _, err := parseKey(str)
+	if err != nil {
+		nagiosplugin.Exit(nagiosplugin.UNKNOWN, err.Error())
+	}
+}
 
+// Exit UNKNOWN if a Nagios threshold range given as a flag can't be parsed
+func validateRange(str string) {
+	_, err := nagiosplugin.ParseRange(str)
+	if err != nil {
+		nagiosplugin.Exit(
+			nagiosplugin.UNKNOWN,
+			fmt.Sprintf("Range '%s' is not a valid Nagios range: %s", str, err),
+		)
+	}
+}
+
+// Convert --key-length limits (min:max, either may be blank) to the
+// Nagios range that alerts outside them
+func lengthRange(str string) (string, error) {
+
+	limits := strings.Split(str, flagSeperator)
+	if len(limits) != 2 || limits[0]+limits[1] == "" {
+		return "", errors.New(
+			fmt.Sprintf("Key length '%s' is not in the format min:max", str),
+		)
+	}
+
+	for _, limit := range limits {
+		if n, err := strconv.Atoi(limit); limit != "" && (err != nil || n < 0) {
+			return "", errors.New(
+				fmt.Sprintf("Key length limit '%s' is not a whole number", limit),
+			)
+		}
+	}
+
+	// The same as the Nagios range min:max, which needs ~ for no minimum
+	if limits[0] == "" {
+		limits[0] = "~"
+	}
+	rng := strings.Join(limits, ":")
+
+	if _, err := nagiosplugin.ParseRange(rng); err != nil {
+		return "", errors.New(
+			fmt.Sprintf("Key length '%s' has a minimum above its maximum", str),
+		)
+	}
+
+	return rng, nil
+}
+
+// A failed check that raises a state other than CRITICAL
+type checkError struct {
+	status nagiosplugin.Status
+	msg    string
+}
+
+func (e checkError) Error() string {
+	return e.msg
+}
+
+// A value that couldn't be tested at all (eg. a string given to --key-lte).
+// Negated tests fail on these too.
+type valueError struct {
+	msg string
+}
+
+func (e valueError) Error() string {
+	return e.msg
+}
+
+// The Nagios state raised by a failed check
+func failStatus(err error) nagiosplugin.Status {
+	if e, ok := err.(checkError); ok {
+		return e.status
+	}
+	return nagiosplugin.CRITICAL
+}
+
+// How bad each Nagios state is. UNKNOWN ranks below WARNING so a check
+// that couldn't run doesn't hide a threshold that was breached.
+var statusSeverity = map[nagiosplugin.Status]int{
+	nagiosplugin.OK:       0,
+	nagiosplugin.UNKNOWN:  1,
+	nagiosplugin.WARNING:  2,
+	nagiosplugin.CRITICAL: 3,
+}
+
+// Whether state a is worse than state b
+func worseStatus(a, b nagiosplugin.Status) bool {
+	return statusSeverity[a] > statusSeverity[b]
+}
+
+// The Nagios state raised when a JSON test fails
+func jsonTestStatus(tst JsonTest) nagiosplugin.Status {
+	switch strings.TrimPrefix(tst.operator, "!") {
+	case "warning", "length-warning":
+		return nagiosplugin.WARNING
+	}
+	return nagiosplugin.CRITICAL
+}
+
+// Build an error raising the given Nagios state
+func statusError(status nagiosplugin.Status, msg string) error {
+	if status == nagiosplugin.CRITICAL {
+		return errors.New(msg)
+	}
+	return checkError{status, msg}
 }
 
 /*
@@ -183,6 +533,24 @@ func checkStatus(status int) (bool, error) {
 	return true, nil // All tests passed, no errors
 }
 
+// Check the time taken to make the request and read the response
+func checkTime(elapsed time.Duration) (bool, error) {
+
+	secs := elapsed.Seconds()
+
+	if crit, ok := TimeTest["critical"]; ok && secs > crit {
+		return false, errors.New(
+			fmt.Sprintf("Response time %.3fs exceeds critical threshold %gs", secs, crit))
+	}
+
+	if warn, ok := TimeTest["warning"]; ok && secs > warn {
+		return false, statusError(nagiosplugin.WARNING,
+			fmt.Sprintf("Response time %.3fs exceeds warning threshold %gs", secs, warn))
+	}
+
+	return true, nil // All tests passed, no errors
+}
+
 // Check the HTTP response size
 func checkPageSize(size int64) (bool, error) {
 
@@ -239,11 +607,57 @@ func checkRegexp(body []byte) (bool, error) {
 	return true, nil // All tests passed, no errors
 }
 
+// Validate the decoded JSON body against the schema. Each violation is
+// reported with the JSON pointer of the offending value.
+func checkSchema(doc interface{}) (bool, error) {
+
+	err := SchemaTest.Validate(doc)
+	if err == nil {
+		return true, nil
+	}
+
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return false, errors.New(
+			fmt.Sprintf("Unable to validate against schema '%s': %s", SchemaFile, err),
+		)
+	}
+
+	violations := make([]string, 0)
+	for _, leaf := range schemaViolations(ve) {
+		violations = append(violations,
+			fmt.Sprintf("#%s %s", leaf.InstanceLocation, leaf.Message))
+	}
+
+	return false, errors.New(
+		fmt.Sprintf("JSON does not match schema '%s' (%d violation(s)): %s",
+			SchemaFile, len(violations), strings.Join(violations, "; ")),
+	)
+}
+
+// The innermost errors of a schema validation, which name the actual
+// violations rather than the keywords that contain them
+func schemaViolations(ve *jsonschema.ValidationError) []*jsonschema.ValidationError {
+
+	if len(ve.Causes) == 0 {
+		return []*jsonschema.ValidationError{ve}
+	}
+
+	leaves := make([]*jsonschema.ValidationError, 0)
+	for _, cause := range ve.Causes {
+		leaves = append(leaves, schemaViolations(cause)...)
+	}
+
+	return leaves
+}
+
 // Check JSON variabes in response body
 func checkJson(j interface{}, tst JsonTest) (bool, error) {
 
-	var match bool
-	var failReasons error
+	quant, path, err := parseKey(tst.key)
+	if err != nil {
+		return false, err
+	}
 
 	// Unmarshall generic decoding:
 	//  - interface{} = strings, integers, and booleans,
@@ -252,101 +666,377 @@ func checkJson(j interface{}, tst JsonTest) (bool, error) {
 	//
 	// See http://stackoverflow.com/a/22470287 &&
 	// http://blog.golang.org/json-and-go
+	found := lookupPath(j, path)
+	if len(found) == 0 {
+		// Nothing found can't match, which is all none() asks for. Absent
+		// keys are what a negated exists test wants.
+		if tst.operator == "!exists" {
+			return true, nil
+		}
+		return quant.kind == "none", nil
+	}
 
-	switch t := j.(type) {
+	// Wildcards and recursive descent can address several values. The
+	// quantifier decides how many of them have to pass.
+	passed := make([]string, 0)
+	failed := make([]string, 0)
+	var failReason error
 
-	// We have a JSON array
-	// eg. ["one", "two", "three"]
-	case []interface{}:
-		for _, v := range t {
-			var result bool
-			// FIXME: Need to pass the key name with the value.
-			result, failReasons = checkJson(v, tst)
-			match = match || result
+	for _, pv := range found {
+		err := checkJsonTest(pv.loc, pv.value, tst)
+		if err != nil {
+			failed = append(failed, pv.loc)
+			// Values can fail with different states (eg. --key-age), so
+			// report the worst
+			if failReason == nil || worseStatus(failStatus(err), failStatus(failReason)) {
+				failReason = err
+			}
+		} else {
+			passed = append(passed, pv.loc)
 		}
+	}
 
-	// We have further JSON objects to decode
-	// eg. {"obj1": {"obj2": {"key": "value"}}}
+	switch quant.kind {
+
+	case "all":
+		if len(failed) != 0 {
+			return true, statusError(failStatus(failReason),
+				fmt.Sprintf("Key '%s' failed for %d of %d values (%s): %s",
+					tst.key, len(failed), len(found),
+					strings.Join(failed, ", "), failReason))
+		}
+
+	case "none":
+		if len(passed) != 0 {
+			return true, statusError(jsonTestStatus(tst),
+				fmt.Sprintf("Key '%s' matched %d of %d values (%s), expected none",
+					tst.key, len(passed), len(found), strings.Join(passed, ", ")))
+		}
+
+	case "atleast":
+		if len(passed) < quant.n {
+			status := jsonTestStatus(tst)
+			if failReason != nil {
+				status = failStatus(failReason)
+			}
+			return true, statusError(status,
+				fmt.Sprintf("Key '%s' passed for %d of %d values, expected at least %d (failed: %s)",
+					tst.key, len(passed), len(found), quant.n,
+					strings.Join(failed, ", ")))
+		}
+
+	default:
+		if len(passed) == 0 {
+			return true, failReason
+		}
+
+	}
+
+	return true, nil
+}
+
+// Decode a JSON document. Numbers are kept as json.Number so large
+// integers and decimals compare exactly rather than as float64.
+func decodeJson(body []byte) (interface{}, error) {
+
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	// Like json.Unmarshal, only a single document is allowed
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+
+	return doc, nil
+}
+
+// A JSON value as it would appear in the document, eg. "10" for a string
+func jsonLiteral(val interface{}) string {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+	return string(b)
+}
+
+// A JSON value as text to match against, strings without their quotes
+func jsonText(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return s
+	}
+	return jsonLiteral(val)
+}
+
+// Whether a JSON value is exactly want, taking its type into account.
+// Numbers compare by value and only match numbers. true, false and null
+// only match themselves, so strings that look like them (or like numbers)
+// need quoting, eg. "3".
+func valueIs(val interface{}, want string, nocase bool) bool {
+
+	equal := func(a, b string) bool {
+		if nocase {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+
+	switch v := val.(type) {
+
+	case json.Number:
+		w, err := parseNumber(want)
+		if err != nil {
+			return false
+		}
+		cmp, ok := compareNumbers(v, w)
+		return ok && cmp == 0
+
+	case string:
+		var quoted string
+		if strings.HasPrefix(want, "\"") && json.Unmarshal([]byte(want), &quoted) == nil {
+			return equal(v, quoted)
+		}
+		if isLiteral(want) {
+			return false
+		}
+		return equal(v, want)
+	}
+
+	return equal(jsonLiteral(val), want)
+}
+
+// Whether a --key-is value is a JSON number, true, false or null rather
+// than a string
+func isLiteral(str string) bool {
+	switch strings.ToLower(str) {
+	case "true", "false", "null":
+		return true
+	}
+	_, err := parseNumber(str)
+	return err == nil
+}
+
+// The number of elements in an array, keys in an object or characters in
+// a string
+func jsonLength(val interface{}) (int, bool) {
+	switch v := val.(type) {
+	case []interface{}:
+		return len(v), true
 	case map[string]interface{}:
-		jsn := j.(map[string]interface{})
+		return len(v), true
+	case string:
+		return utf8.RuneCountInString(v), true
+	}
+	return 0, false
+}
 
-		// If jsn has the key we're looking for. Test it.
-		if jsn[tst.key] != nil {
+// Types accepted by --key-type. Integers are numbers without a fraction.
+var jsonTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"null": true, "array": true, "object": true,
+}
 
-			match, failReasons = checkJsonValue(jsn, tst)
+// The JSON type of a decoded value
+func jsonType(val interface{}) string {
+	switch val.(type) {
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
 
-		} else { // Do further unzipping. There might be more objects.
-			match = false
+// Whether a decoded value has the named JSON type
+func isJsonType(val interface{}, name string) bool {
+	if name == "integer" {
+		n, ok := val.(json.Number)
+		if !ok {
+			return false
+		}
+		r, ok := new(big.Rat).SetString(n.String())
+		return ok && r.IsInt()
+	}
+	return jsonType(val) == name
+}
 
-			for _, v := range t {
-				result, err := checkJson(v, tst)
-				match = match || result
-				if err != nil {
-					failReasons = err
-				}
-			}
+// Check a JSON value found at the given key path, applying negation
+func checkJsonTest(key string, val interface{}, tst JsonTest) error {
 
+	if !strings.HasPrefix(tst.operator, "!") {
+		_, err := checkJsonValue(key, val, tst)
+		return err
+	}
+
+	positive := tst
+	positive.operator = negateOperator(tst.operator)
+	if _, err := checkJsonValue(key, val, positive); err != nil {
+		if _, ok := err.(valueError); ok {
+			return err
 		}
+		return nil // Failing the test is what a negated test wants
+	}
 
+	if positive.operator == "exists" {
+		return statusError(jsonTestStatus(tst),
+			fmt.Sprintf("Key '%s' is present with value %s, expected it to be absent",
+				key, jsonLiteral(val)))
 	}
 
-	return match, failReasons
+	return statusError(jsonTestStatus(tst),
+		fmt.Sprintf("Key '%s' value %s %s, expected it not to",
+			key, jsonLiteral(val), positive.phrase()))
 }
 
-// Check the value of a specific JSON key:value object
-func checkJsonValue(jsn map[string]interface{}, tst JsonTest) (bool, error) {
+// Check a JSON value found at the given key path
+func checkJsonValue(key string, val interface{}, tst JsonTest) (bool, error) {
 
 	// Switch based on test type
 	switch tst.operator {
 
 	case "equals":
 		// Convert JSON value to string and do a regex match
-		jv := fmt.Sprintf("%s", jsn[tst.key])
-		match, _ := regexp.MatchString(tst.value.(string), jv)
+		jv := jsonText(val)
+		if opts.StrictEquals {
+			jv = jsonLiteral(val)
+		}
+		// Strict matches are anchored too, or true would match inside "true"
+		pattern := tst.value.(string)
+		if opts.AnchorEquals || opts.StrictEquals {
+			pattern = "^(?:" + pattern + ")$"
+		}
+		match, _ := regexp.MatchString(pattern, jv)
 		if !match {
 			return true,
 				errors.New(
-					fmt.Sprintf("Key '%s' does not equal '%s'", tst.key, tst.value),
+					fmt.Sprintf("Key '%s' does not equal '%s'", key, tst.value),
 				)
 		}
 
-	case "lte":
-		_, ok := jsn[tst.key].(float64)
-		if !ok {
+	case "is", "is-nocase":
+		if !valueIs(val, tst.value.(string), tst.operator == "is-nocase") {
 			return true,
 				errors.New(
-					fmt.Sprintf("Key '%s' value is not an integer", tst.key),
+					fmt.Sprintf("Key '%s' value %s is not '%s'", key, jsonLiteral(val), tst.value),
 				)
 		}
 
-		if tst.value.(float64) < jsn[tst.key].(float64) {
+	case "in", "in-nocase":
+		match := false
+		for _, want := range tst.value.([]string) {
+			if valueIs(val, want, tst.operator == "in-nocase") {
+				match = true
+				break
+			}
+		}
+		if !match {
 			return true,
 				errors.New(
-					fmt.Sprintf("Key '%s' is greater than '%g'", tst.key, tst.value),
+					fmt.Sprintf("Key '%s' value %s is not one of %s",
+						key, jsonLiteral(val), quoteList(tst.value.([]string))),
 				)
 		}
 
-	case "gte":
-		_, ok := jsn[tst.key].(float64)
+	case "type":
+		if !isJsonType(val, tst.value.(string)) {
+			return true,
+				errors.New(
+					fmt.Sprintf("Key '%s' value %s has type %s, expected %s",
+						key, jsonLiteral(val), jsonType(val), tst.value),
+				)
+		}
+
+	case "lte":
+		n, ok := val.(json.Number)
 		if !ok {
+			return true,
+				valueError{
+					fmt.Sprintf("Key '%s' value %s is not a number", key, jsonLiteral(val)),
+				}
+		}
+
+		if cmp, _ := compareNumbers(n, tst.value.(json.Number)); cmp > 0 {
 			return true,
 				errors.New(
-					fmt.Sprintf("Key '%s' value is not an integer", tst.key),
+					fmt.Sprintf("Key '%s' value '%s' is greater than '%s'", key, n, tst.value),
 				)
 		}
 
-		if tst.value.(float64) > jsn[tst.key].(float64) {
+	case "gte":
+		n, ok := val.(json.Number)
+		if !ok {
+			return true,
+				valueError{
+					fmt.Sprintf("Key '%s' value %s is not a number", key, jsonLiteral(val)),
+				}
+		}
+
+		if cmp, _ := compareNumbers(n, tst.value.(json.Number)); cmp < 0 {
 			return true,
 				errors.New(
-					fmt.Sprintf("Key '%s' is less than '%g'", tst.key, tst.value),
+					fmt.Sprintf("Key '%s' value '%s' is less than '%s'", key, n, tst.value),
 				)
 		}
 
-	case "exists":
-		if jsn[tst.key] == nil {
-			return false, nil
+	case "warning", "critical":
+		n, ok := val.(json.Number)
+		if !ok {
+			return true,
+				valueError{
+					fmt.Sprintf("Key '%s' value %s is not a number", key, jsonLiteral(val)),
+				}
 		}
 
+		// Nagios ranges are floating point. Validated when the flag was parsed
+		v, _ := n.Float64()
+		rng, _ := nagiosplugin.ParseRange(tst.value.(string))
+		if rng.Check(v) {
+			return true,
+				statusError(jsonTestStatus(tst),
+					fmt.Sprintf("Key '%s' value '%s' breaches %s threshold '%s'",
+						key, n, tst.operator, tst.value),
+				)
+		}
+
+	case "length-warning", "length-critical":
+		n, ok := jsonLength(val)
+		if !ok {
+			return true,
+				valueError{
+					fmt.Sprintf("Key '%s' value %s has no length", key, jsonLiteral(val)),
+				}
+		}
+
+		// Validated when the flag was parsed
+		rng, _ := nagiosplugin.ParseRange(tst.value.(string))
+		if rng.Check(float64(n)) {
+			return true,
+				statusError(jsonTestStatus(tst),
+					fmt.Sprintf("Key '%s' length %d breaches %s threshold '%s'",
+						key, n, strings.TrimPrefix(tst.operator, "length-"), tst.value),
+				)
+		}
+
+	case "age":
+		if err := checkAge(key, val, tst.value.(AgeThresholds), time.Now()); err != nil {
+			return true, err
+		}
+
+	case "exists":
+		// Already found by the path lookup
+
 	}
 	return true, nil // Json key exists and all tests passed
 }
@@ -3,8 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
 )
 
 /*
@@ -19,6 +25,14 @@ type TestStatusCase struct {
 	errStr string
 }
 
+type TestTimeCase struct {
+	warning  float64
+	critical float64
+	send     time.Duration
+	status   nagiosplugin.Status
+	errStr   string
+}
+
 type TestPageSizeCase struct {
 	option func(string)
 	param  string
@@ -51,6 +65,14 @@ type TestJsonCase struct {
 	errStr string
 }
 
+type TestJsonThresholdCase struct {
+	option func(string)
+	param  string
+	send   []byte
+	status nagiosplugin.Status
+	errStr string
+}
+
 type TestJsonValueCase struct {
 	jsonBlob []byte
 	test     JsonTest
@@ -58,6 +80,23 @@ type TestJsonValueCase struct {
 	errStr   string
 }
 
+type TestJsonStringCase struct {
+	test JsonTest
+	desc string
+}
+
+type TestLengthRangeCase struct {
+	limits string
+	rng    string
+	ok     bool
+}
+
+type TestSchemaCase struct {
+	jsonBlob []byte
+	match    bool
+	errStr   string
+}
+
 /*
  * Tests for primary functions
  */
@@ -91,6 +130,47 @@ func Test_checkStatus(t *testing.T) {
 	}
 }
 
+func Test_checkTime(t *testing.T) {
+
+	cases := []TestTimeCase{
+		{1, 2, 500 * time.Millisecond, nagiosplugin.OK, ""},
+		{1, 2, 1500 * time.Millisecond, nagiosplugin.WARNING,
+			"Response time 1.500s exceeds warning threshold 1s"},
+		{1, 2, 2500 * time.Millisecond, nagiosplugin.CRITICAL,
+			"Response time 2.500s exceeds critical threshold 2s"},
+		{0, 0.25, 300 * time.Millisecond, nagiosplugin.CRITICAL,
+			"Response time 0.300s exceeds critical threshold 0.25s"},
+	}
+
+	for _, c := range cases {
+		// Clear old settings
+		delete(TimeTest, "warning")
+		delete(TimeTest, "critical")
+
+		// eg. opts.FlagWarning(1) simulates --warning=1
+		if c.warning != 0 {
+			opts.FlagWarning(c.warning)
+		}
+		opts.FlagCritical(c.critical)
+
+		// Test the flag that drives the test
+		expect(t, Tests["time"], true)
+
+		// Run response time check
+		match, err := checkTime(c.send)
+		expect(t, c.status == nagiosplugin.OK, match)
+
+		if c.status != nagiosplugin.OK {
+			expect(t, c.status, failStatus(err))
+			expect(t, c.errStr, err.Error())
+		}
+
+	}
+
+	delete(TimeTest, "warning")
+	delete(TimeTest, "critical")
+}
+
 func Test_checkPageSize(t *testing.T) {
 
 	cases := []TestPageSizeCase{
@@ -240,19 +320,252 @@ func Test_checkJson(t *testing.T) {
 
 		{opts.FlagKeyLte, "foo:10",
 			[]byte(`{"foo":"bar", "baz":"qux"}`), true,
-			"Key 'foo' value is not an integer"},
+			"Key 'foo' value \"bar\" is not a number"},
 
 		{opts.FlagKeyLte, "foo:1",
 			[]byte(`{"baz":"qux", "foo":1000000000000000}`), true,
-			"Key 'foo' is greater than '1'"},
+			"Key 'foo' value '1000000000000000' is greater than '1'"},
 
 		{opts.FlagKeyGte, "foo:10",
 			[]byte(`{"baz":"qux", "foo":"10"}`), true,
-			"Key 'foo' value is not an integer"},
+			"Key 'foo' value \"10\" is not a number"},
 
 		{opts.FlagKeyGte, "foo:1000",
 			[]byte(`{"baz":"qux", "foo":1}`), true,
-			"Key 'foo' is less than '1000'"},
+			"Key 'foo' value '1' is less than '1000'"},
+
+		// Integers beyond float64 precision compare exactly
+		{opts.FlagKeyLte, "seq:9007199254740992",
+			[]byte(`{"seq":9007199254740993}`), true,
+			"Key 'seq' value '9007199254740993' is greater than '9007199254740992'"},
+
+		{opts.FlagKeyGte, "ts:1700000000000000001",
+			[]byte(`{"ts":1700000000000000001}`), true, ""},
+
+		// Decimals compare exactly, keeping the original literal
+		{opts.FlagKeyLte, "ratio:0.3",
+			[]byte(`{"ratio":0.30000000000000001}`), true,
+			"Key 'ratio' value '0.30000000000000001' is greater than '0.3'"},
+
+		{opts.FlagKeyGte, "ratio:1e-1",
+			[]byte(`{"ratio":0.10}`), true, ""},
+
+		{opts.FlagKeyEquals, "id:^12345678901234567890$",
+			[]byte(`{"id":12345678901234567890}`), true, ""},
+
+		// Separators inside JSONPath brackets and values are kept
+		{opts.FlagKeyEquals, "$.items[1:].state:^ok$",
+			[]byte(`{"items":[{"id":2,"state":"down"}, {"id":2,"state":"ok"}]}`),
+			true, ""},
+
+		{opts.FlagKeyEquals, "$.components[?(@.name=='db')].status:up",
+			[]byte(`{"components":[{"name":"db","status":"down"}]}`), true,
+			"Key 'components[0].status' does not equal 'up'"},
+
+		{opts.FlagKeyEquals, "time:^12:00",
+			[]byte(`{"time":"12:00:01"}`), true, ""},
+
+		// Top-level arrays and scalars
+		{opts.FlagKeyEquals, "$[0].name:web",
+			[]byte(`[{"name":"web"},{"name":"db"}]`), true, ""},
+
+		{opts.FlagKeyGte, "$.length:3",
+			[]byte(`[{"name":"web"},{"name":"db"}]`), true,
+			"Key 'length' value '2' is less than '3'"},
+
+		{opts.FlagKeyEquals, "$:true",
+			[]byte(`true`), true, ""},
+
+		{opts.FlagKeyExists, "$[0]",
+			[]byte(`[]`), false, ""},
+
+		// Values are matched as text, null and booleans as their literals
+		{opts.FlagKeyEquals, "error:^null$",
+			[]byte(`{"error":null}`), true, ""},
+
+		{opts.FlagKeyEquals, "enabled:^true$",
+			[]byte(`{"enabled":true}`), true, ""},
+
+		{opts.FlagKeyEquals, "tags:db",
+			[]byte(`{"tags":["web","db"]}`), true, ""},
+
+		// Types
+		{opts.FlagKeyType, "name:string",
+			[]byte(`{"name":"web"}`), true, ""},
+
+		{opts.FlagKeyType, "count:integer",
+			[]byte(`{"count":5}`), true, ""},
+
+		{opts.FlagKeyType, "ratio:number",
+			[]byte(`{"ratio":0.5}`), true, ""},
+
+		{opts.FlagKeyType, "$:array",
+			[]byte(`[1, 2]`), true, ""},
+
+		{opts.FlagKeyType, "enabled:boolean",
+			[]byte(`{"enabled":"true"}`), true,
+			"Key 'enabled' value \"true\" has type string, expected boolean"},
+
+		{opts.FlagKeyType, "count:integer",
+			[]byte(`{"count":5.5}`), true,
+			"Key 'count' value 5.5 has type number, expected integer"},
+
+		{opts.FlagKeyType, "error:object",
+			[]byte(`{"error":null}`), true,
+			"Key 'error' value null has type null, expected object"},
+
+		// Negated tests
+		{opts.FlagKeyAbsent, "error",
+			[]byte(`{"status":"ok"}`), true, ""},
+
+		{opts.FlagKeyAbsent, "error",
+			[]byte(`{"status":"ok","error":"timeout"}`), true,
+			"Key 'error' is present with value \"timeout\", expected it to be absent"},
+
+		{opts.FlagKeyNotEquals, "state:degraded",
+			[]byte(`{"state":"healthy"}`), true, ""},
+
+		{opts.FlagKeyNotEquals, "state:degraded",
+			[]byte(`{"state":"degraded"}`), true,
+			"Key 'state' value \"degraded\" matches 'degraded', expected it not to"},
+
+		{opts.FlagKeyNotEquals, "state:degraded",
+			[]byte(`{"status":"ok"}`), false, ""},
+
+		{opts.FlagKeyNotEquals, "all(nodes[*].state):^down$",
+			[]byte(`{"nodes":[{"state":"up"},{"state":"down"}]}`), true,
+			"Key 'all(nodes[*].state)' failed for 1 of 2 values (nodes[1].state): " +
+				"Key 'nodes[1].state' value \"down\" matches '^down$', expected it not to"},
+
+		{opts.FlagKeyType, "!error:null",
+			[]byte(`{"error":{"code":500}}`), true, ""},
+
+		{opts.FlagKeyType, "!error:null",
+			[]byte(`{"error":null}`), true,
+			"Key 'error' value null has type 'null', expected it not to"},
+
+		{opts.FlagKeyGte, "!queue.depth:100",
+			[]byte(`{"queue":{"depth":150}}`), true,
+			"Key 'queue.depth' value 150 is at least '100', expected it not to"},
+
+		{opts.FlagKeyExists, "!error",
+			[]byte(`{}`), true, ""},
+
+		// Values that can't be tested fail negated tests too
+		{opts.FlagKeyLte, "!x:5",
+			[]byte(`{"x":"abc"}`), true,
+			"Key 'x' value \"abc\" is not a number"},
+
+		{opts.FlagKeyWarning, "!x:10",
+			[]byte(`{"x":true}`), true,
+			"Key 'x' value true is not a number"},
+
+		{opts.FlagKeyLengthCritical, "!count:10",
+			[]byte(`{"count":3}`), true,
+			"Key 'count' value 3 has no length"},
+
+		// Double negation
+		{opts.FlagKeyNotEquals, "!state:^ok$",
+			[]byte(`{"state":"ok"}`), true, ""},
+
+		// Exact values and sets
+		{opts.FlagKeyIs, "status:ok",
+			[]byte(`{"status":"ok"}`), true, ""},
+
+		{opts.FlagKeyIs, "status:ok",
+			[]byte(`{"status":"broken"}`), true,
+			"Key 'status' value \"broken\" is not 'ok'"},
+
+		{opts.FlagKeyIs, "status:ok",
+			[]byte(`{"status":"OK"}`), true,
+			"Key 'status' value \"OK\" is not 'ok'"},
+
+		{opts.FlagKeyIsNocase, "status:ok",
+			[]byte(`{"status":"OK"}`), true, ""},
+
+		{opts.FlagKeyIs, "replicas:3",
+			[]byte(`{"replicas":3.0}`), true, ""},
+
+		{opts.FlagKeyIs, "enabled:true",
+			[]byte(`{"enabled":true}`), true, ""},
+
+		{opts.FlagKeyIs, "error:null",
+			[]byte(`{"error":null}`), true, ""},
+
+		{opts.FlagKeyIs, "enabled:true",
+			[]byte(`{"enabled":"true"}`), true,
+			"Key 'enabled' value \"true\" is not 'true'"},
+
+		{opts.FlagKeyIs, "replicas:3",
+			[]byte(`{"replicas":"3"}`), true,
+			"Key 'replicas' value \"3\" is not '3'"},
+
+		{opts.FlagKeyIs, "status:ok",
+			[]byte(`{"status":true}`), true,
+			"Key 'status' value true is not 'ok'"},
+
+		{opts.FlagKeyIs, `version:"3"`,
+			[]byte(`{"version":"3"}`), true, ""},
+
+		{opts.FlagKeyIs, `version:"3"`,
+			[]byte(`{"version":3}`), true,
+			"Key 'version' value 3 is not '\"3\"'"},
+
+		{opts.FlagKeyIsNocase, "enabled:TRUE",
+			[]byte(`{"enabled":true}`), true, ""},
+
+		{opts.FlagKeyIn, "code:200,204",
+			[]byte(`{"code":"204"}`), true,
+			"Key 'code' value \"204\" is not one of '200', '204'"},
+
+		{opts.FlagKeyIn, "state:running,starting",
+			[]byte(`{"state":"starting"}`), true, ""},
+
+		{opts.FlagKeyIn, "state:running,starting",
+			[]byte(`{"state":"Running"}`), true,
+			"Key 'state' value \"Running\" is not one of 'running', 'starting'"},
+
+		{opts.FlagKeyInNocase, "state:running,starting",
+			[]byte(`{"state":"Running"}`), true, ""},
+
+		{opts.FlagKeyIn, "code:200,204",
+			[]byte(`{"code":204}`), true, ""},
+
+		// Lengths of arrays, objects and strings
+		{opts.FlagKeyLength, "nodes:3:",
+			[]byte(`{"nodes":[1,2,3]}`), true, ""},
+
+		{opts.FlagKeyLength, "nodes:3:",
+			[]byte(`{"nodes":[1,2]}`), true,
+			"Key 'nodes' length 2 breaches critical threshold '3:'"},
+
+		{opts.FlagKeyLength, "errors::0",
+			[]byte(`{"errors":[]}`), true, ""},
+
+		{opts.FlagKeyLength, "errors::0",
+			[]byte(`{"errors":["disk full"]}`), true,
+			"Key 'errors' length 1 breaches critical threshold '~:0'"},
+
+		{opts.FlagKeyLength, "labels:1:5",
+			[]byte(`{"labels":{"a":1,"b":2}}`), true, ""},
+
+		{opts.FlagKeyLength, "name:1:3",
+			[]byte(`{"name":"ünï"}`), true, ""},
+
+		{opts.FlagKeyLength, "count:1:",
+			[]byte(`{"count":5}`), true,
+			"Key 'count' value 5 has no length"},
+
+		{opts.FlagKeyLengthWarning, "nodes:5:",
+			[]byte(`{"nodes":[1,2,3]}`), true,
+			"Key 'nodes' length 3 breaches warning threshold '5:'"},
+
+		{opts.FlagKeyLengthCritical, "nodes:10",
+			[]byte(`{"nodes":[1,2,3]}`), true, ""},
+
+		{opts.FlagKeyIn, "!state:failed,stopped",
+			[]byte(`{"state":"failed"}`), true,
+			"Key 'state' value \"failed\" is one of 'failed', 'stopped', expected it not to"},
 	}
 
 	for _, c := range cases {
@@ -269,12 +582,11 @@ func Test_checkJson(t *testing.T) {
 		expect(t, Tests["keys"], true)
 
 		// Unmarshall JSON in test case
-		var jsonMap map[string]interface{}
-		err := json.Unmarshal(c.send, &jsonMap)
+		jsonDoc, err := decodeJson(c.send)
 		check(err)
 
 		// Run Json test
-		match, err := checkJson(jsonMap, JsonTests[0])
+		match, err := checkJson(jsonDoc, JsonTests[0])
 		expect(t, c.match, match)
 
 		// If we didn't expect a match, test the error code
@@ -285,6 +597,69 @@ func Test_checkJson(t *testing.T) {
 	}
 }
 
+func Test_checkJson_Thresholds(t *testing.T) {
+
+	cases := []TestJsonThresholdCase{
+		{opts.FlagKeyWarning, "queue.depth:100",
+			[]byte(`{"queue":{"depth":50}}`), nagiosplugin.OK, ""},
+
+		{opts.FlagKeyWarning, "queue.depth:100",
+			[]byte(`{"queue":{"depth":150}}`), nagiosplugin.WARNING,
+			"Key 'queue.depth' value '150' breaches warning threshold '100'"},
+
+		{opts.FlagKeyCritical, "queue.depth:500",
+			[]byte(`{"queue":{"depth":501}}`), nagiosplugin.CRITICAL,
+			"Key 'queue.depth' value '501' breaches critical threshold '500'"},
+
+		{opts.FlagKeyWarning, "free:10:",
+			[]byte(`{"free":5}`), nagiosplugin.WARNING,
+			"Key 'free' value '5' breaches warning threshold '10:'"},
+
+		{opts.FlagKeyWarning, "temp:~:10",
+			[]byte(`{"temp":-40}`), nagiosplugin.OK, ""},
+
+		{opts.FlagKeyCritical, "load:@10:20",
+			[]byte(`{"load":15}`), nagiosplugin.CRITICAL,
+			"Key 'load' value '15' breaches critical threshold '@10:20'"},
+
+		{opts.FlagKeyCritical, "load:@10:20",
+			[]byte(`{"load":25}`), nagiosplugin.OK, ""},
+
+		{opts.FlagKeyWarning, "all(nodes[*].lag):5",
+			[]byte(`{"nodes":[{"lag":1}, {"lag":9}]}`), nagiosplugin.WARNING,
+			"Key 'all(nodes[*].lag)' failed for 1 of 2 values (nodes[1].lag): " +
+				"Key 'nodes[1].lag' value '9' breaches warning threshold '5'"},
+	}
+
+	for _, c := range cases {
+
+		// Clear old settings
+		Tests["keys"] = false
+		JsonTests = JsonTests[:0]
+
+		// Call the option with the param specified in the case.
+		// eg. opts.FlagKeyWarning("foo:10") simulates --key-warning=foo:10
+		c.option(c.param)
+
+		// Test the flag that drives the test
+		expect(t, Tests["keys"], true)
+
+		jsonDoc, err := decodeJson(c.send)
+		check(err)
+
+		match, err := checkJson(jsonDoc, JsonTests[0])
+		expect(t, true, match)
+
+		if c.status == nagiosplugin.OK {
+			expect(t, nil, err)
+		} else {
+			expect(t, c.status, failStatus(err))
+			expect(t, c.errStr, err.Error())
+		}
+
+	}
+}
+
 func Test_checkJsonValue(t *testing.T) {
 
 	var cases = []TestJsonValueCase{
@@ -293,36 +668,62 @@ func Test_checkJsonValue(t *testing.T) {
 			true, ""},
 
 		{[]byte(`{"Animal":{"Name":"Platypus", "Order":"Monotremata"}}`),
-			JsonTest{"Name", "Platypus", "equals"},
+			JsonTest{"Animal.Name", "Platypus", "equals"},
+			true, ""},
+
+		{[]byte(`{"Animal":{"Mammal":{"Name":"Platypus"}}}`),
+			JsonTest{"Animal.Mammal.Name", "Platypus", "equals"},
 			true, ""},
 
+		// Nested keys are only searched for with recursive descent
 		{[]byte(`{"Animal":{"Mammal":{"Name":"Platypus"}}}`),
 			JsonTest{"Name", "Platypus", "equals"},
+			false, ""},
+
+		{[]byte(`{"Animal":{"Mammal":{"Name":"Platypus"}}}`),
+			JsonTest{"..Name", "Platypus", "equals"},
+			true, ""},
+
+		// Don't match an unrelated nested key of the same name
+		{[]byte(`{"status":"ok", "db":{"status":"down"}}`),
+			JsonTest{"status", "ok", "equals"},
 			true, ""},
 
+		{[]byte(`{"status":"ok", "db":{"status":"down"}}`),
+			JsonTest{"db.status", "ok", "equals"},
+			true, "Key 'db.status' does not equal 'ok'"},
+
 		{[]byte(`[{"Foo":1,"Baz":"Qux"}, {"success":true}]`),
-			JsonTest{"success", "true", "equals"},
+			JsonTest{"[1].success", "true", "equals"},
+			true, ""},
+
+		{[]byte(`[{"Foo":1,"Baz":"Qux"}, {"success":true}]`),
+			JsonTest{"..success", "true", "equals"},
 			true, ""},
 
 		{[]byte(`[{"Foo":1,"Baz":"Qux"}, {"success":true}, {"success":false}]`),
-			JsonTest{"success", "true", "equals"},
+			JsonTest{"[*].success", "true", "equals"},
 			true, ""},
 
+		{[]byte(`[{"Foo":1,"Baz":"Qux"}, {"success":true}, {"success":false}]`),
+			JsonTest{"[-1].success", "true", "equals"},
+			true, "Key '[2].success' does not equal 'true'"},
+
 		{[]byte(`[{"Foo":100,"Baz":"Qux"}]`),
-			JsonTest{"Foo", 150.00, "lte"},
+			JsonTest{"[0].Foo", json.Number("150"), "lte"},
 			true, ""},
 
 		{[]byte(`[{"Foo":100,"Baz":"Qux"}]`),
-			JsonTest{"Foo", 50.00, "lte"},
-			true, "Key 'Foo' is greater than '50'"},
+			JsonTest{"[0].Foo", json.Number("50"), "lte"},
+			true, "Key '[0].Foo' value '100' is greater than '50'"},
 
 		{[]byte(`[{"Foo":100,"Baz":"Qux"}]`),
-			JsonTest{"Foo", 50.00, "gte"},
+			JsonTest{"[0].Foo", json.Number("50"), "gte"},
 			true, ""},
 
 		{[]byte(`[{"Foo":100,"Baz":"Qux"}]`),
-			JsonTest{"Foo", 150.00, "gte"},
-			true, "Key 'Foo' is less than '150'"},
+			JsonTest{"[0].Foo", json.Number("150"), "gte"},
+			true, "Key '[0].Foo' value '100' is less than '150'"},
 
 		{[]byte(`{"Wibble":"Wobble","Baz":"Qux"}`),
 			JsonTest{"Foo", "Baz", "equals"},
@@ -332,9 +733,46 @@ func Test_checkJsonValue(t *testing.T) {
 			JsonTest{"Foo", "Bar", "equals"},
 			true, "Key 'Foo' does not equal 'Bar'"},
 
+		// Quantifiers across array elements
+		{[]byte(`{"replicas":[{"ok":true}, {"ok":true}]}`),
+			JsonTest{"all(replicas[*].ok)", "true", "equals"},
+			true, ""},
+
+		{[]byte(`{"replicas":[{"ok":true}, {"ok":false}, {"ok":false}]}`),
+			JsonTest{"all(replicas[*].ok)", "true", "equals"},
+			true, "Key 'all(replicas[*].ok)' failed for 2 of 3 values " +
+				"(replicas[1].ok, replicas[2].ok): " +
+				"Key 'replicas[1].ok' does not equal 'true'"},
+
+		{[]byte(`{"nodes":[{"state":"up"}, {"state":"up"}]}`),
+			JsonTest{"none(nodes[*].state)", "failed", "equals"},
+			true, ""},
+
+		{[]byte(`{"nodes":[{"state":"up"}, {"state":"failed"}]}`),
+			JsonTest{"none(nodes[*].state)", "failed", "equals"},
+			true, "Key 'none(nodes[*].state)' matched 1 of 2 values " +
+				"(nodes[1].state), expected none"},
+
+		{[]byte(`{"nodes":[]}`),
+			JsonTest{"none(nodes[*].state)", "failed", "equals"},
+			true, ""},
+
+		{[]byte(`{"nodes":[{"lag":1}, {"lag":50}, {"lag":3}]}`),
+			JsonTest{"atleast(2, nodes[*].lag)", json.Number("10"), "lte"},
+			true, ""},
+
+		{[]byte(`{"nodes":[{"lag":1}, {"lag":50}, {"lag":30}]}`),
+			JsonTest{"atleast(2, nodes[*].lag)", json.Number("10"), "lte"},
+			true, "Key 'atleast(2, nodes[*].lag)' passed for 1 of 3 values, " +
+				"expected at least 2 (failed: nodes[1].lag, nodes[2].lag)"},
+
+		{[]byte(`{"nodes":[{"lag":1}, {"lag":50}]}`),
+			JsonTest{"any(nodes[*].lag)", json.Number("10"), "gte"},
+			true, ""},
+
 		// Null array test. Should return "Key not found"
 		{[]byte(`[]`),
-			JsonTest{"success", "true", "equals"},
+			JsonTest{"[*].success", "true", "equals"},
 			false, ""},
 
 		// Null object test. Should return "Key not found"
@@ -345,8 +783,7 @@ func Test_checkJsonValue(t *testing.T) {
 
 	for _, c := range cases {
 
-		var result interface{}
-		err := json.Unmarshal(c.jsonBlob, &result)
+		result, err := decodeJson(c.jsonBlob)
 		if err != nil {
 			fmt.Println("error unzipping json:", err)
 		}
@@ -361,3 +798,202 @@ func Test_checkJsonValue(t *testing.T) {
 
 	}
 }
+
+func Test_checkSchema(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "check-json")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	schema := filepath.Join(dir, "status.schema.json")
+	err = ioutil.WriteFile(schema, []byte(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"required": ["status", "nodes"],
+		"properties": {
+			"status": {"enum": ["ok", "degraded"]},
+			"nodes": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"required": ["id"],
+					"properties": {"id": {"type": "integer"}}
+				}
+			}
+		}
+	}`), 0644)
+	check(err)
+
+	opts.FlagSchema(schema)
+	expect(t, true, Tests["schema"])
+
+	cases := []TestSchemaCase{
+		{[]byte(`{"status":"ok","nodes":[{"id":1},{"id":2}]}`), true, ""},
+
+		{[]byte(`{"status":"down","nodes":[]}`), false,
+			fmt.Sprintf("JSON does not match schema '%s' (1 violation(s)): "+
+				"#/status value must be one of \"ok\", \"degraded\"", schema)},
+
+		{[]byte(`{"status":"ok","nodes":[{"id":1},{"id":"2"},{}]}`), false,
+			fmt.Sprintf("JSON does not match schema '%s' (2 violation(s)): "+
+				"#/nodes/1/id expected integer, but got string; "+
+				"#/nodes/2 missing properties: 'id'", schema)},
+
+		{[]byte(`[]`), false,
+			fmt.Sprintf("JSON does not match schema '%s' (1 violation(s)): "+
+				"# expected object, but got array", schema)},
+	}
+
+	for _, c := range cases {
+		doc, err := decodeJson(c.jsonBlob)
+		check(err)
+
+		match, err := checkSchema(doc)
+		expect(t, c.match, match)
+
+		if c.errStr != "" {
+			expect(t, c.errStr, err.Error())
+		} else {
+			expect(t, nil, err)
+		}
+	}
+
+	Tests["schema"] = false
+	SchemaTest = nil
+	SchemaFile = ""
+}
+
+func Test_checkJson_StrictEquals(t *testing.T) {
+
+	cases := []TestJsonValueCase{
+		{[]byte(`{"enabled":true}`),
+			JsonTest{"enabled", "^true$", "equals"}, true, ""},
+
+		{[]byte(`{"enabled":"true"}`),
+			JsonTest{"enabled", "^true$", "equals"}, true,
+			"Key 'enabled' does not equal '^true$'"},
+
+		{[]byte(`{"count":5}`),
+			JsonTest{"count", "^5$", "equals"}, true, ""},
+
+		{[]byte(`{"count":"5"}`),
+			JsonTest{"count", "^5$", "equals"}, true,
+			"Key 'count' does not equal '^5$'"},
+
+		{[]byte(`{"name":"web"}`),
+			JsonTest{"name", `^"web"$`, "equals"}, true, ""},
+
+		// Strict patterns match the whole value
+		{[]byte(`{"enabled":true}`),
+			JsonTest{"enabled", "true", "equals"}, true, ""},
+
+		{[]byte(`{"enabled":"true"}`),
+			JsonTest{"enabled", "true", "equals"}, true,
+			"Key 'enabled' does not equal 'true'"},
+
+		{[]byte(`{"count":"5"}`),
+			JsonTest{"count", "5", "equals"}, true,
+			"Key 'count' does not equal '5'"},
+
+		{[]byte(`{"name":"webserver"}`),
+			JsonTest{"name", `"web.*"`, "equals"}, true, ""},
+	}
+
+	opts.StrictEquals = true
+
+	for _, c := range cases {
+		doc, err := decodeJson(c.jsonBlob)
+		check(err)
+
+		match, err := checkJson(doc, c.test)
+		expect(t, c.match, match)
+
+		if c.errStr != "" {
+			expect(t, c.errStr, err.Error())
+		} else {
+			expect(t, nil, err)
+		}
+	}
+
+	opts.StrictEquals = false
+}
+
+func Test_JsonTest_String(t *testing.T) {
+
+	cases := []TestJsonStringCase{
+		{JsonTest{"status", "", "exists"}, "Key 'status' exists"},
+		{JsonTest{"error", "", "!exists"}, "Key 'error' is absent"},
+		{JsonTest{"state", "^ok$", "equals"}, "Key 'state' matches '^ok$'"},
+		{JsonTest{"state", "degraded", "!equals"}, "Key 'state' does not match 'degraded'"},
+		{JsonTest{"depth", json.Number("10"), "lte"}, "Key 'depth' is at most '10'"},
+		{JsonTest{"depth", json.Number("10"), "!lte"}, "Key 'depth' is more than '10'"},
+		{JsonTest{"error", "null", "!type"}, "Key 'error' does not have type 'null'"},
+		{JsonTest{"depth", "100", "!warning"}, "Key 'depth' is outside warning threshold '100'"},
+		{JsonTest{"status", "ok", "is"}, "Key 'status' is 'ok'"},
+		{JsonTest{"status", "ok", "is-nocase"}, "Key 'status' is 'ok' ignoring case"},
+		{JsonTest{"state", []string{"a", "b"}, "in"}, "Key 'state' is one of 'a', 'b'"},
+		{JsonTest{"state", []string{"a", "b"}, "!in"}, "Key 'state' is not one of 'a', 'b'"},
+	}
+
+	for _, c := range cases {
+		expect(t, c.desc, c.test.String())
+	}
+}
+
+func Test_checkJson_AnchorEquals(t *testing.T) {
+
+	cases := []TestJsonValueCase{
+		{[]byte(`{"status":"ok"}`),
+			JsonTest{"status", "ok", "equals"}, true, ""},
+
+		{[]byte(`{"status":"broken"}`),
+			JsonTest{"status", "ok", "equals"}, true,
+			"Key 'status' does not equal 'ok'"},
+
+		{[]byte(`{"status":"degraded"}`),
+			JsonTest{"status", "ok|degraded", "equals"}, true, ""},
+
+		{[]byte(`{"version":"2.14.1"}`),
+			JsonTest{"version", `2\.\d+`, "equals"}, true,
+			"Key 'version' does not equal '2\\.\\d+'"},
+	}
+
+	opts.AnchorEquals = true
+
+	for _, c := range cases {
+		doc, err := decodeJson(c.jsonBlob)
+		check(err)
+
+		match, err := checkJson(doc, c.test)
+		expect(t, c.match, match)
+
+		if c.errStr != "" {
+			expect(t, c.errStr, err.Error())
+		} else {
+			expect(t, nil, err)
+		}
+	}
+
+	opts.AnchorEquals = false
+}
+
+func Test_lengthRange(t *testing.T) {
+
+	cases := []TestLengthRangeCase{
+		{"3:", "3:", true},
+		{":0", "~:0", true},
+		{"1:5", "1:5", true},
+		{"3:3", "3:3", true},
+		{"5:3", "", false},
+		{":", "", false},
+		{"3", "", false},
+		{"-1:", "", false},
+		{"a:5", "", false},
+	}
+
+	for _, c := range cases {
+		rng, err := lengthRange(c.limits)
+		expect(t, c.ok, err == nil)
+		expect(t, c.rng, rng)
+	}
+}
@@ -1,10 +1,24 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
 )
 
 /*
@@ -12,8 +26,24 @@ import (
  */
 
 type BuildUrlCase struct {
-	ssl                 bool
-	hostname, uri, want string
+	ssl       bool
+	hostname  string
+	port      int
+	uri, want string
+}
+
+type ParseUrlCase struct {
+	url, want string
+}
+
+type ProxyCase struct {
+	proxy, body string
+}
+
+type AddressCase struct {
+	hostname, ip string
+	ipv4         bool
+	host         string
 }
 
 type HttpRequestCase struct {
@@ -23,6 +53,36 @@ type HttpRequestCase struct {
 	size int64
 }
 
+type TimeoutFlagCase struct {
+	param   string
+	timeout time.Duration
+	status  nagiosplugin.Status
+}
+
+type ClientTimeoutCase struct {
+	headerTimeout float64
+	timeout       time.Duration
+}
+
+type RedirectCase struct {
+	onRedirect string
+	path       string
+	status     int
+	url        string
+	redirects  []string
+	state      nagiosplugin.Status
+	stopped    bool
+}
+
+type TlsOptionsCase struct {
+	caFile   bool
+	insecure bool
+	sni      string
+	minVer   string
+	clientCa bool
+	ok       bool
+}
+
 /*
  * Tests for primary functions
  */
@@ -31,13 +91,19 @@ func Test_buildUrl_Success(t *testing.T) {
 
 	// Normal URL build cases
 	cases := []BuildUrlCase{
-		{false, "localhost", "/wibble", "http://localhost/wibble"},
-		{true, "localhost", "/wibble", "https://localhost/wibble"},
-		{false, "localhost", "", "http://localhost/"},
+		{false, "localhost", 0, "/wibble", "http://localhost/wibble"},
+		{true, "localhost", 0, "/wibble", "https://localhost/wibble"},
+		{false, "localhost", 0, "", "http://localhost/"},
+		{false, "localhost", 8080, "/wibble", "http://localhost:8080/wibble"},
+		{true, "10.0.0.1", 8443, "/", "https://10.0.0.1:8443/"},
+		{false, "::1", 0, "/", "http://[::1]/"},
+		{false, "[::1]", 8080, "/", "http://[::1]:8080/"},
+		{false, "localhost:8080", 0, "/", "http://localhost:8080/"},
+		{false, "[::1]:8080", 0, "/", "http://[::1]:8080/"},
 	}
 
 	for _, c := range cases {
-		got, _ := buildUrl(c.ssl, c.hostname, c.uri)
+		got, _ := buildUrl(c.ssl, c.hostname, c.port, c.uri)
 		expect(t, c.want, got)
 	}
 
@@ -45,12 +111,45 @@ func Test_buildUrl_Success(t *testing.T) {
 
 func Test_buildUrl_Errors(t *testing.T) {
 	// URL build error cases
-	cases := []BuildUrlCase{{false, "", "/wibble", ""}}
+	cases := []BuildUrlCase{
+		{false, "", 0, "/wibble", ""},
+		{false, "localhost", 70000, "/wibble", ""},
+		{false, "localhost:8080", 9090, "/wibble", ""},
+	}
+
+	for _, c := range cases {
+		_, err := buildUrl(c.ssl, c.hostname, c.port, c.uri)
+		expectErr(t, err)
+	}
+}
+
+func Test_parseUrl(t *testing.T) {
+
+	err := os.Setenv("CHECKJSONSERVICE", "billing api")
+	check(err)
+
+	cases := []ParseUrlCase{
+		{"https://host:8443/path?x=y", "https://host:8443/path?x=y"},
+		{"http://host", "http://host/"},
+		{"http://[::1]:8080/health", "http://[::1]:8080/health"},
+		{"http://host/q?a=%2F&b=c", "http://host/q?a=%2F&b=c"},
+		{"http://host/q?svc={{ env CHECKJSONSERVICE }}&x=1",
+			"http://host/q?svc=billing+api&x=1"},
+	}
 
 	for _, c := range cases {
-		_, err := buildUrl(c.ssl, c.hostname, c.uri)
+		got, err := parseUrl(c.url)
+		expect(t, nil, err)
+		expect(t, c.want, got)
+	}
+
+	for _, bad := range []string{"host/path", "ftp://host/", "http:///path", "http://host:x/"} {
+		_, err := parseUrl(bad)
 		expectErr(t, err)
 	}
+
+	err = os.Setenv("CHECKJSONSERVICE", "")
+	check(err)
 }
 
 func Test_httpRequest(t *testing.T) {
@@ -65,19 +164,447 @@ func Test_httpRequest(t *testing.T) {
 		ts := httpServer(c.code, c.hdrs, c.body)
 		defer ts.Close()
 
-		code, hdrs, body, size := httpRequest("GET", ts.URL, "")
+		resp := httpRequest("GET", ts.URL, "")
 
-		expect(t, c.code, code)
-		expect(t, c.body, strings.TrimSpace(fmt.Sprintf("%s", body)))
-		expect(t, c.size, size)
+		expect(t, c.code, resp.Status)
+		expect(t, c.body, strings.TrimSpace(fmt.Sprintf("%s", resp.Body)))
+		expect(t, c.size, resp.Size)
+		expect(t, true, resp.Elapsed > 0)
 
 		// Iterate through headers set during test case and make sure it
 		// is in the response
 		for hdrKey, hdrArray := range c.hdrs {
 			for i, hdrVal := range hdrArray {
-				expect(t, hdrVal, hdrs[hdrKey][i])
+				expect(t, hdrVal, resp.Headers[hdrKey][i])
+			}
+		}
+
+	}
+}
+
+func Test_timeoutFlag(t *testing.T) {
+
+	cases := []TimeoutFlagCase{
+		{"5", 5 * time.Second, nagiosplugin.CRITICAL},
+		{"2.5:unknown", 2500 * time.Millisecond, nagiosplugin.UNKNOWN},
+		{"1:WARNING", time.Second, nagiosplugin.WARNING},
+		{"30:0", 30 * time.Second, nagiosplugin.OK},
+	}
+
+	for _, c := range cases {
+		TimeoutStatus = nagiosplugin.CRITICAL
+
+		// eg. httpOpts.Timeout("5") simulates --timeout=5
+		httpOpts.Timeout(c.param)
+
+		expect(t, c.timeout, RequestTimeout)
+		expect(t, c.status, TimeoutStatus)
+	}
+
+	RequestTimeout = 10 * time.Second
+	TimeoutStatus = nagiosplugin.CRITICAL
+}
+
+func Test_httpClient_Timeouts(t *testing.T) {
+
+	// Server that takes too long to send headers
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprintln(w, "too slow")
+	}))
+	defer ts.Close()
+
+	cases := []ClientTimeoutCase{
+		{0.05, 10 * time.Second},   // Header timeout
+		{0, 50 * time.Millisecond}, // Overall timeout
+	}
+
+	for _, c := range cases {
+		httpOpts.HeaderTimeout = c.headerTimeout
+		RequestTimeout = c.timeout
+
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		check(err)
+
+		phase := "connect"
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), requestTrace(&phase)))
+
+		_, err = httpClient().Do(req)
+		ne, ok := err.(net.Error)
+		expect(t, true, ok && ne.Timeout())
+		expect(t, "response headers", phase)
+	}
+
+	httpOpts.HeaderTimeout = 0
+	RequestTimeout = 10 * time.Second
+}
+
+func Test_httpClient_Tls(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "check-json")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	// Client certificate for mutual TLS
+	clientCert, clientKey := selfSignedCert("check-json client")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	check(ioutil.WriteFile(certFile, clientCert, 0600))
+	check(ioutil.WriteFile(keyFile, clientKey, 0600))
+
+	clientPool := x509.NewCertPool()
+	clientPool.AppendCertsFromPEM(clientCert)
+
+	cases := []TlsOptionsCase{
+		{false, false, "", "", false, false}, // Unknown CA
+		{true, false, "", "", false, true},
+		{false, true, "", "", false, true},
+		{true, false, "example.com", "", false, true},
+		{true, false, "wrong.example.net", "", false, false},
+		{true, false, "", "1.3", false, false}, // Server only speaks 1.2
+		{true, false, "", "", true, false},     // Missing client cert
+	}
+
+	for _, c := range cases {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"ok":true}`)
+		}))
+		ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+		if c.clientCa {
+			ts.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+			ts.TLS.ClientCAs = clientPool
+		}
+		ts.StartTLS()
+
+		caFile := filepath.Join(dir, "ca.pem")
+		check(ioutil.WriteFile(caFile, pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+		httpOpts.CaFile = ""
+		if c.caFile {
+			httpOpts.CaFile = caFile
+		}
+		httpOpts.Insecure = c.insecure
+		httpOpts.Sni = c.sni
+		httpOpts.TlsMinVersion = c.minVer
+
+		resp, err := httpClient().Get(ts.URL)
+		expect(t, c.ok, err == nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		// The same server accepts our client certificate
+		if c.clientCa {
+			httpOpts.ClientCert = certFile
+			httpOpts.ClientKey = keyFile
+
+			resp, err := httpClient().Get(ts.URL)
+			expect(t, nil, err)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+
+		httpOpts.ClientCert = ""
+		httpOpts.ClientKey = ""
+		ts.Close()
+	}
+
+	httpOpts.CaFile = ""
+	httpOpts.Insecure = false
+	httpOpts.Sni = ""
+	httpOpts.TlsMinVersion = ""
+}
+
+func Test_tlsConfig_Errors(t *testing.T) {
+
+	httpOpts.CaFile = "/nonexistent/ca.pem"
+	_, err := tlsConfig()
+	expectErr(t, err)
+	httpOpts.CaFile = ""
+
+	httpOpts.ClientCert = "/nonexistent/client.pem"
+	_, err = tlsConfig()
+	expectErr(t, err)
+	httpOpts.ClientCert = ""
+
+	httpOpts.ClientKey = "/nonexistent/client.key"
+	_, err = tlsConfig()
+	expectErr(t, err)
+	httpOpts.ClientKey = ""
+}
+
+// Generate a PEM encoded self signed certificate and key
+func selfSignedCert(name string) ([]byte, []byte) {
+
+	cert, key := testCert(name, time.Now().Add(time.Hour), nil, nil)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	check(err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func Test_httpRequest_Redirects(t *testing.T) {
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// A closed port and a host name that doesn't resolve, only reachable
+	// by sticking to the original server
+	closed := httptest.NewServer(nil)
+	closedPort := closed.Listener.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"ok":true}`)
+	})
+	mux.Handle("/sticky", http.RedirectHandler(
+		fmt.Sprintf("http://sticky.invalid:%s/c", port), http.StatusFound))
+	mux.Handle("/stickyport", http.RedirectHandler(
+		fmt.Sprintf("http://sticky.invalid:%d/c", closedPort), http.StatusFound))
+
+	cases := []RedirectCase{
+		{"follow", "/c", 200, ts.URL + "/c", []string{},
+			nagiosplugin.OK, false},
+		{"follow", "/a", 200, ts.URL + "/c", []string{ts.URL + "/a", ts.URL + "/b"},
+			nagiosplugin.OK, false},
+		{"ok", "/a", 302, ts.URL + "/a", []string{},
+			nagiosplugin.OK, true},
+		{"warning", "/a", 302, ts.URL + "/a", []string{},
+			nagiosplugin.WARNING, true},
+		{"critical", "/b", 301, ts.URL + "/b", []string{},
+			nagiosplugin.CRITICAL, true},
+		{"sticky", "/sticky", 200, fmt.Sprintf("http://sticky.invalid:%s/c", port),
+			[]string{ts.URL + "/sticky"}, nagiosplugin.OK, false},
+		{"stickyport", "/stickyport", 200,
+			fmt.Sprintf("http://sticky.invalid:%d/c", closedPort),
+			[]string{ts.URL + "/stickyport"}, nagiosplugin.OK, false},
+	}
+
+	httpOpts.MaxRedirects = 10
+
+	for _, c := range cases {
+		httpOpts.OnRedirect = c.onRedirect
+
+		resp := httpRequest("GET", ts.URL+c.path, "")
+		expect(t, c.status, resp.Status)
+		expect(t, c.url, resp.URL)
+		expect(t, len(c.redirects), len(resp.Redirects))
+		for i := 0; i < len(c.redirects) && i < len(resp.Redirects); i++ {
+			expect(t, c.redirects[i], resp.Redirects[i])
+		}
+
+		state, stopped := redirectState(resp)
+		expect(t, c.stopped, stopped)
+		expect(t, c.state, state)
+	}
+
+	// Too many redirects
+	httpOpts.OnRedirect = "follow"
+	httpOpts.MaxRedirects = 1
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	check(err)
+	expect(t, nil, checkRedirect(req, make([]*http.Request, 1)))
+	expect(t, errTooManyRedirects, checkRedirect(req, make([]*http.Request, 2)))
+
+	// Back to the flag defaults
+	httpOpts.OnRedirect = "follow"
+	httpOpts.MaxRedirects = 10
+}
+
+func Test_httpRequest_Address(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// node.invalid doesn't resolve, so the request only works by
+	// connecting to the IP address
+	cases := []AddressCase{
+		{"node.invalid", "127.0.0.1", false, "node.invalid:" + port},
+		{"node.invalid", "127.0.0.1", true, "node.invalid:" + port},
+		{"", "", true, "127.0.0.1:" + port},
+		{"node.invalid:" + port, "127.0.0.1", false, "node.invalid:" + port},
+	}
+
+	for _, c := range cases {
+		httpOpts.Hostname = c.hostname
+		httpOpts.IpAddress = c.ip
+		httpOpts.Ipv4 = c.ipv4
+
+		hostname := c.hostname
+		if hostname == "" {
+			hostname = "127.0.0.1"
+		}
+
+		// Hostnames may already carry the port
+		urlPort := ts.Listener.Addr().(*net.TCPAddr).Port
+		if strings.Contains(hostname, ":") {
+			urlPort = 0
+		}
+		url, err := buildUrl(false, hostname, urlPort, "/")
+		check(err)
+
+		resp := httpRequest("GET", url, "")
+		expect(t, 200, resp.Status)
+		expect(t, c.host, string(resp.Body))
+	}
+
+	httpOpts.Hostname = ""
+	httpOpts.IpAddress = ""
+	httpOpts.Ipv4 = false
+}
+
+func Test_httpRequest_Proxy(t *testing.T) {
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"via":"direct"}`)
+	}))
+	defer backend.Close()
+
+	// Stand-in HTTP proxy, answering for backend.invalid itself
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		fmt.Fprintf(w, `{"via":"http","host":"%s"}`, r.URL.Host)
+	}))
+	defer proxy.Close()
+
+	// Stand-in SOCKS5 proxy, connecting every request to the backend
+	socks := socksProxy(backend.Listener.Addr().String())
+	defer socks.Close()
+
+	cases := []ProxyCase{
+		{"http://user:pass@" + proxy.Listener.Addr().String(),
+			`{"via":"http","host":"backend.invalid"}`},
+		{"http://" + proxy.Listener.Addr().String(), ""},
+		{"socks5://user:pass@" + socks.Addr().String(), `{"via":"direct"}`},
+	}
+
+	for _, c := range cases {
+		httpOpts.Proxy = c.proxy
+
+		resp := httpRequest("GET", "http://backend.invalid/", "")
+		expect(t, c.body, string(resp.Body))
+	}
+
+	// Invalid proxies
+	for _, bad := range []string{"proxy:3128", "ftp://proxy", "http://"} {
+		httpOpts.Proxy = bad
+		_, err := proxyFunc()
+		expectErr(t, err)
+	}
+
+	// Ignoring the environment
+	httpOpts.Proxy = ""
+	httpOpts.NoEnvProxy = true
+	proxyFn, err := proxyFunc()
+	expect(t, nil, err)
+	expect(t, true, proxyFn == nil)
+
+	httpOpts.NoEnvProxy = false
+}
+
+// A minimal SOCKS5 proxy accepting user:pass, which connects every request
+// to target
+func socksProxy(target string) net.Listener {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	check(err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
 			}
+			go socksConn(conn, target)
 		}
+	}()
+
+	return ln
+}
+
+func socksConn(conn net.Conn, target string) {
+
+	defer conn.Close()
+	buf := make([]byte, 256)
 
+	// Greeting, choose username/password authentication
+	io.ReadFull(conn, buf[:2])
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{5, 2})
+
+	// Username and password
+	io.ReadFull(conn, buf[:2])
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	pass := make([]byte, buf[0])
+	io.ReadFull(conn, pass)
+	if string(user) != "user" || string(pass) != "pass" {
+		conn.Write([]byte{1, 1})
+		return
 	}
+	conn.Write([]byte{1, 0})
+
+	// Connect request for a host name and port
+	io.ReadFull(conn, buf[:5])
+	io.ReadFull(conn, buf[:buf[4]+2])
+
+	backend, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+}
+
+func Test_httpRequest_UnixSocket(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "check-json")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "api.sock")
+	ln, err := net.Listen("unix", socket)
+	check(err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"path":"%s","host":"%s"}`, r.URL.Path, r.Host)
+	}))
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+
+	httpOpts.UnixSocket = socket
+
+	resp := httpRequest("GET", "http://localhost/containers/json", "")
+	expect(t, 200, resp.Status)
+	expect(t, `{"path":"/containers/json","host":"localhost"}`, string(resp.Body))
+
+	// Proxies don't apply to sockets
+	httpOpts.Proxy = "http://proxy.invalid:3128"
+	_, err = proxyFunc()
+	expectErr(t, err)
+
+	httpOpts.Proxy = ""
+	httpOpts.UnixSocket = ""
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+type InputOptions struct {
+	File string `long:"file" description:"Check a JSON document in a file instead of making an HTTP request"`
+
+	Stdin bool `long:"stdin" description:"Check a JSON document read from standard input instead of making an HTTP request" default:"false"`
+
+	Exec string `long:"exec" description:"Check the JSON output of a command (eg. \"ceph status -f json\") instead of making an HTTP request. Not run through a shell, but arguments can be quoted (eg. \"jq -r '.a b' f.json\")"`
+}
+
+var inputOpts InputOptions
+
+// Tests that only make sense for an HTTP response
+var httpOnlyTests = map[string]string{
+	"status":      "--status",
+	"headers":     "--header-equals",
+	"certificate": "--certificate",
+}
+
+func init() {
+	parser.AddGroup("Input Options", "Input", &inputOpts)
+}
+
+// The input mode chosen by flags, or "" to make an HTTP request
+func inputMode() (string, error) {
+
+	modes := make([]string, 0)
+	if inputOpts.File != "" {
+		modes = append(modes, "file")
+	}
+	if inputOpts.Stdin {
+		modes = append(modes, "stdin")
+	}
+	if inputOpts.Exec != "" {
+		modes = append(modes, "exec")
+	}
+
+	switch len(modes) {
+	case 0:
+		return "", nil
+	case 1:
+	default:
+		return "", errors.New("Only one of --file, --stdin and --exec can be given")
+	}
+
+	for test, flag := range httpOnlyTests {
+		if Tests[test] {
+			return "", errors.New(fmt.Sprintf("%s only applies to HTTP requests, not --%s", flag, modes[0]))
+		}
+	}
+
+	return modes[0], nil
+}
+
+// Read the document to check from a file, stdin or a command. It is
+// returned as a response so the body tests work the same as for HTTP.
+func readInput(mode string) HttpResponse {
+
+	var body []byte
+	var err error
+	var source string
+
+	start := time.Now()
+
+	switch mode {
+	case "file":
+		source = inputOpts.File
+		body, err = ioutil.ReadFile(inputOpts.File)
+		if err != nil {
+			checkArg(errors.New(fmt.Sprintf("Unable to read '%s': %s", source, err)))
+		}
+
+	case "stdin":
+		source = "stdin"
+		body, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			checkArg(errors.New(fmt.Sprintf("Unable to read stdin: %s", err)))
+		}
+
+	case "exec":
+		source = inputOpts.Exec
+		body = runCommand(inputOpts.Exec)
+	}
+
+	return HttpResponse{
+		Body:    body,
+		Size:    int64(len(body)),
+		Elapsed: time.Since(start),
+		URL:     source,
+	}
+}
+
+// Run a command, returning what it printed on stdout. The request timeout
+// applies to the command too.
+func runCommand(command string) []byte {
+
+	args, err := splitCommand(command)
+	checkArg(err)
+	if len(args) == 0 {
+		checkArg(errors.New("Command to run is blank"))
+	}
+
+	ctx := context.Background()
+	if RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, RequestTimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		nagiosplugin.Exit(
+			TimeoutStatus,
+			fmt.Sprintf("Timed out running '%s' after %s", command, RequestTimeout),
+		)
+	}
+
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) != 0 {
+		err = errors.New(fmt.Sprintf("%s: %s", err, ee.Stderr))
+	}
+	checkInput(err, fmt.Sprintf("Command '%s' failed", command))
+
+	return out
+}
+
+// Split a command into arguments like a shell would, without expanding
+// anything. Single quotes keep text as is, double quotes allow \" and \\
+// escapes, and a backslash outside quotes escapes the next character.
+func splitCommand(command string) ([]string, error) {
+
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, c := range command {
+		switch {
+		case escaped:
+			if quote == '"' && c != '"' && c != '\\' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			escaped = false
+
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New(fmt.Sprintf("Command '%s' has an unclosed quote or trailing '\\'", command))
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// Exit if a command couldn't be run, with the state chosen by
+// --on-connect-failure like an unreachable server
+func checkInput(e error, msg string) {
+	if e == nil {
+		return
+	}
+
+	nagiosplugin.Exit(connectFailureStatus(), oneLine(fmt.Sprintf("%s: %s", msg, e)))
+}
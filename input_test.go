@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * Data models to hold input test cases
+ */
+
+type InputModeCase struct {
+	file  string
+	stdin bool
+	exec  string
+	tests []string
+	mode  string
+	ok    bool
+}
+
+type SplitCommandCase struct {
+	command string
+	args    []string
+	ok      bool
+}
+
+type ReadInputCase struct {
+	mode, file, exec string
+	body, url        string
+}
+
+/*
+ * Tests for primary functions
+ */
+
+func Test_inputMode(t *testing.T) {
+
+	cases := []InputModeCase{
+		{"", false, "", nil, "", true},
+		{"status.json", false, "", nil, "file", true},
+		{"", true, "", []string{"keys", "regexp"}, "stdin", true},
+		{"", false, "ceph status -f json", []string{"time"}, "exec", true},
+		{"status.json", true, "", nil, "", false},
+		{"status.json", false, "", []string{"status"}, "", false},
+		{"", false, "ceph status", []string{"certificate"}, "", false},
+	}
+
+	for _, c := range cases {
+		inputOpts = InputOptions{File: c.file, Stdin: c.stdin, Exec: c.exec}
+		Tests = make(map[string]bool)
+		for _, tst := range c.tests {
+			Tests[tst] = true
+		}
+
+		mode, err := inputMode()
+		expect(t, c.ok, err == nil)
+		expect(t, c.mode, mode)
+	}
+
+	inputOpts = InputOptions{}
+	Tests = make(map[string]bool)
+}
+
+func Test_splitCommand(t *testing.T) {
+
+	cases := []SplitCommandCase{
+		{"ceph status -f json", []string{"ceph", "status", "-f", "json"}, true},
+		{"  ceph\tstatus  ", []string{"ceph", "status"}, true},
+		{`jq -r '.a b' f.json`, []string{"jq", "-r", ".a b", "f.json"}, true},
+		{`jq "{\"a\": .b}" 'it'\''s'`, []string{"jq", `{"a": .b}`, "it's"}, true},
+		{`echo "a\nb" a\ b ''`, []string{"echo", `a\nb`, "a b", ""}, true},
+		{"", []string{}, true},
+		{`jq '.a`, nil, false},
+		{`echo a\`, nil, false},
+	}
+
+	for _, c := range cases {
+		args, err := splitCommand(c.command)
+		expect(t, c.ok, err == nil)
+		expect(t, len(c.args), len(args))
+
+		for i := 0; i < len(c.args) && i < len(args); i++ {
+			expect(t, c.args[i], args[i])
+		}
+	}
+}
+
+func Test_readInput(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "check-json")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "status.json")
+	err = ioutil.WriteFile(file, []byte(`{"job":"backup","ok":true}`), 0644)
+	check(err)
+
+	cases := []ReadInputCase{
+		{"file", file, "", `{"job":"backup","ok":true}`, file},
+		{"exec", "", `echo '{"ok":true}'`, "{\"ok\":true}\n", `echo '{"ok":true}'`},
+		{"exec", "", `printf "%s %s" 'a b' c`, "a b c", `printf "%s %s" 'a b' c`},
+	}
+
+	for _, c := range cases {
+		inputOpts = InputOptions{File: c.file, Exec: c.exec}
+
+		resp := readInput(c.mode)
+		expect(t, c.body, string(resp.Body))
+		expect(t, int64(len(c.body)), resp.Size)
+		expect(t, c.url, resp.URL)
+		expect(t, 0, resp.Status)
+	}
+
+	inputOpts = InputOptions{}
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/fractalcat/nagiosplugin"
 	"github.com/jessevdk/go-flags"
@@ -25,9 +26,10 @@ func main() {
 	// the correct output and return code if we terminate unexpectedly.
 	defer nagiosCheck.Finish()
 
-	// Parse flags and quit if none supplied.
+	// Parse flags and quit if none supplied. go-flags has already printed
+	// the usage or error, so exit UNKNOWN like check_http.
 	if _, err := parser.Parse(); err != nil {
-		os.Exit(1)
+		os.Exit(int(nagiosplugin.UNKNOWN))
 	}
 
 	// Preflight checks. Add headers, do auth, etc.
@@ -35,46 +37,89 @@ func main() {
 		preflightChecks[i]()
 	}
 
-	url, err := buildUrl(httpOpts.Ssl, httpOpts.Hostname, httpOpts.Uri)
-	check(err)
-	status, hdrs, body, size := httpRequest(httpOpts.Method, url, httpOpts.Post)
+	// Check a document from a file, stdin or command, or make the request
+	mode, err := inputMode()
+	checkArg(err)
 
-	if Tests["status"] {
-		match, reason := checkStatus(status)
-		if !match {
-			FailReasons = append(FailReasons, reason)
+	var resp HttpResponse
+	if mode != "" {
+		resp = readInput(mode)
+	} else {
+		url, err := requestUrl()
+		checkArg(err)
+		resp = httpRequest(httpOpts.Method, url, httpOpts.Post)
+	}
+	responsePerfData(nagiosCheck, resp)
+
+	// Redirects that weren't followed end the check, like check_http
+	if state, redirected := redirectState(resp); redirected {
+		desc := fmt.Sprintf("Redirected to %s", resp.Headers["Location"][0])
+		if state == nagiosplugin.OK {
+			recordResult(true, nil, desc)
+		} else {
+			recordResult(false, statusError(state, desc), desc)
 		}
+
+		nagiosCheck.AddResult(summarise(resp))
+		return
+	}
+
+	if Tests["time"] {
+		match, reason := checkTime(resp.Elapsed)
+		recordResult(match, reason,
+			fmt.Sprintf("Response time was %.3fs", resp.Elapsed.Seconds()))
+	}
+
+	if Tests["certificate"] {
+		now := time.Now()
+		match, reason := checkCertificate(resp.TLS, requestHost(resp.URL), now)
+		recordResult(match, reason, describeCertificate(resp.TLS, now))
+		certificatePerfData(nagiosCheck, resp.TLS, now)
+	}
+
+	if Tests["status"] {
+		match, reason := checkStatus(resp.Status)
+		recordResult(match, reason,
+			fmt.Sprintf("HTTP Status Code was '%d'", resp.Status))
 	}
 
 	if Tests["page-size"] {
-		match, reason := checkPageSize(size)
-		if !match {
-			FailReasons = append(FailReasons, reason)
-		}
+		match, reason := checkPageSize(resp.Size)
+		recordResult(match, reason,
+			fmt.Sprintf("HTTP Response Size was '%d'", resp.Size))
 	}
 
 	if Tests["headers"] {
 		// Test headers(eg. conten-type=json)
-		match, reason := checkHeaders(map[string][]string(hdrs))
-		if !match {
-			FailReasons = append(FailReasons, reason)
-		}
+		match, reason := checkHeaders(resp.Headers)
+		recordResult(match, reason, "HTTP response headers matched")
 	}
 
 	if Tests["regexp"] {
-		match, reason := checkRegexp(body)
-		if !match {
-			FailReasons = append(FailReasons, reason)
-		}
+		match, reason := checkRegexp(resp.Body)
+		recordResult(match, reason,
+			fmt.Sprintf("Regexp '%s' in HTTP response", RegexpTest.String()))
 	}
 
-	if Tests["keys"] {
-		// Unmarshal JSON into a map of strings
-		// Variables will have to cast to be used.
-		var respJson map[string]interface{}
-		err = json.Unmarshal(body, &respJson)
-		check(err)
+	// Unmarshal JSON into a generic value. The document may be an object,
+	// an array or a scalar, so variables will have to cast to be used.
+	var respJson interface{}
+	if Tests["keys"] || Tests["schema"] || len(PerfKeys) != 0 {
+		respJson, err = decodeJson(resp.Body)
+		checkResponse(err)
 
+		jsonPerfData(nagiosCheck, respJson)
+		agePerfData(nagiosCheck, respJson, time.Now())
+		lengthPerfData(nagiosCheck, respJson)
+	}
+
+	if Tests["schema"] {
+		match, reason := checkSchema(respJson)
+		recordResult(match, reason,
+			fmt.Sprintf("JSON matches schema '%s'", SchemaFile))
+	}
+
+	if Tests["keys"] {
 		// Test keys in JSON response
 		for _, tst := range JsonTests {
 			match, reason := checkJson(respJson, tst)
@@ -83,21 +128,113 @@ func main() {
 				reason = errors.New(fmt.Sprintf("Key '%s' not in JSON response", tst.key))
 			}
 
-			if reason != nil {
-				FailReasons = append(FailReasons, reason)
-			}
+			recordResult(match, reason, tst.String())
+		}
+
+	}
+
+	nagiosCheck.AddResult(summarise(resp))
+
+	return
+}
+
+// The URL to request, from --url or the separate server options
+func requestUrl() (string, error) {
+
+	if httpOpts.Ipv4 && httpOpts.Ipv6 {
+		return "", errors.New("Only one of --use-ipv4 and --use-ipv6 can be given")
+	}
+
+	if httpOpts.Url != "" {
+		if httpOpts.Hostname != "" || httpOpts.Port != 0 || httpOpts.Ssl {
+			return "", errors.New("--url already names the server, don't give --hostname, --port or --ssl")
+		}
+
+		url, err := parseUrl(httpOpts.Url)
+		if err != nil {
+			return "", err
 		}
 
+		// --IP-address replaces the server named in the URL
+		httpOpts.Hostname = requestHost(url)
+		return url, nil
 	}
 
+	// Without a host name the IP address is the server, like check_http.
+	// Unix sockets still need a Host header, so default to localhost.
+	hostname := httpOpts.Hostname
+	if hostname == "" {
+		hostname = httpOpts.IpAddress
+	}
+	if hostname == "" && httpOpts.UnixSocket != "" {
+		hostname = "localhost"
+	}
+
+	return buildUrl(httpOpts.Ssl, hostname, httpOpts.Port, httpOpts.Uri)
+}
+
+// Record the outcome of a test. Passed tests are described by desc.
+func recordResult(match bool, reason error, desc string) {
+	if !match || reason != nil {
+		FailReasons = append(FailReasons, reason)
+	} else {
+		PassReasons = append(PassReasons, desc)
+	}
+}
+
+// Build the plugin output from the recorded test results. The first line
+// counts the failures and shows the worst one, the long output below it
+// lists every failed (and with --show-passed, passed) test.
+func summarise(resp HttpResponse) (nagiosplugin.Status, string) {
+
+	status := nagiosplugin.OK
+	var summary string
+	lines := make([]string, 0)
+
 	if len(FailReasons) != 0 {
-		nagiosplugin.Exit(
-			nagiosplugin.CRITICAL,
-			fmt.Sprintf("Test(s) Failed: %s\n", FailReasons[0]),
-		)
+		// Show the first failure raising the worst state. CRITICAL beats
+		// WARNING beats UNKNOWN, whatever order the tests ran in.
+		worst := FailReasons[0]
+		for _, reason := range FailReasons {
+			if worseStatus(failStatus(reason), failStatus(worst)) {
+				worst = reason
+			}
+		}
+		status = failStatus(worst)
+
+		summary = fmt.Sprintf("%d of %d test(s) failed: %s",
+			len(FailReasons), len(FailReasons)+len(PassReasons), worst)
+
+		for _, reason := range FailReasons {
+			lines = append(lines,
+				fmt.Sprintf("%s: %s", failStatus(reason), reason))
+		}
 	} else {
-		nagiosCheck.AddResult(nagiosplugin.OK, "All tests passed")
+		summary = fmt.Sprintf("All %d test(s) passed in %.3fs",
+			len(PassReasons), resp.Elapsed.Seconds())
+
+		// Always show the certificate checked, like check_http -C
+		if Tests["certificate"] {
+			summary += ": " + describeCertificate(resp.TLS, time.Now())
+		}
 	}
 
-	return
+	// Say where the tests ran if redirects were followed
+	if len(resp.Redirects) != 0 {
+		summary += fmt.Sprintf(" (at %s after %d redirect(s))",
+			resp.URL, len(resp.Redirects))
+	}
+
+	if opts.ShowPassed {
+		for _, desc := range PassReasons {
+			lines = append(lines, fmt.Sprintf("OK: %s", desc))
+		}
+	}
+
+	// Nagios long output goes on the lines after the summary
+	if len(lines) != 0 {
+		summary += "\n" + strings.Join(lines, "\n")
+	}
+
+	return status, summary
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+/*
+ * Data models to hold output test cases
+ */
+
+type TestSummariseCase struct {
+	redirects  []string
+	fails      []error
+	passes     []string
+	showPassed bool
+	status     nagiosplugin.Status
+	output     string
+}
+
+type TestRequestUrlCase struct {
+	url      string
+	hostname string
+	port     int
+	ssl      bool
+	want     string
+	ok       bool
+}
+
+/*
+ * Tests for primary functions
+ */
+
+func Test_summarise(t *testing.T) {
+
+	cases := []TestSummariseCase{
+		{nil, nil, []string{"Key 'foo' exists", "HTTP Status Code was '200'"},
+			false, nagiosplugin.OK,
+			"All 2 test(s) passed in 0.250s"},
+
+		{nil, nil, []string{"Key 'foo' exists"},
+			true, nagiosplugin.OK,
+			"All 1 test(s) passed in 0.250s\n" +
+				"OK: Key 'foo' exists"},
+
+		{nil, []error{
+			statusError(nagiosplugin.WARNING, "Response time 0.250s exceeds warning threshold 0.1s"),
+			errors.New("Key 'foo' not in JSON response"),
+			errors.New("HTTP Status Code was '500', expected '200'")},
+			[]string{"Regexp 'ok' in HTTP response"},
+			true, nagiosplugin.CRITICAL,
+			"3 of 4 test(s) failed: Key 'foo' not in JSON response\n" +
+				"WARNING: Response time 0.250s exceeds warning threshold 0.1s\n" +
+				"CRITICAL: Key 'foo' not in JSON response\n" +
+				"CRITICAL: HTTP Status Code was '500', expected '200'\n" +
+				"OK: Regexp 'ok' in HTTP response"},
+
+		{nil, []error{
+			statusError(nagiosplugin.WARNING, "Response time 0.250s exceeds warning threshold 0.1s")},
+			[]string{"Key 'foo' exists"},
+			false, nagiosplugin.WARNING,
+			"1 of 2 test(s) failed: Response time 0.250s exceeds warning threshold 0.1s\n" +
+				"WARNING: Response time 0.250s exceeds warning threshold 0.1s"},
+
+		// The worst state wins, not the first failure
+		{nil, []error{
+			statusError(nagiosplugin.UNKNOWN, "No server certificate to check, the request did not use TLS"),
+			statusError(nagiosplugin.WARNING, "Key 'depth' value '150' breaches warning threshold '100'")},
+			nil,
+			false, nagiosplugin.WARNING,
+			"2 of 2 test(s) failed: Key 'depth' value '150' breaches warning threshold '100'\n" +
+				"UNKNOWN: No server certificate to check, the request did not use TLS\n" +
+				"WARNING: Key 'depth' value '150' breaches warning threshold '100'"},
+
+		{nil, []error{
+			statusError(nagiosplugin.WARNING, "Key 'depth' value '150' breaches warning threshold '100'"),
+			statusError(nagiosplugin.UNKNOWN, "No server certificate to check, the request did not use TLS")},
+			nil,
+			false, nagiosplugin.WARNING,
+			"2 of 2 test(s) failed: Key 'depth' value '150' breaches warning threshold '100'\n" +
+				"WARNING: Key 'depth' value '150' breaches warning threshold '100'\n" +
+				"UNKNOWN: No server certificate to check, the request did not use TLS"},
+
+		// Tests ran against a redirected URL
+		{[]string{"http://localhost/old", "http://localhost/older"}, nil,
+			[]string{"Key 'foo' exists"},
+			false, nagiosplugin.OK,
+			"All 1 test(s) passed in 0.250s " +
+				"(at http://localhost/new after 2 redirect(s))"},
+	}
+
+	for _, c := range cases {
+		FailReasons = append(FailReasons[:0], c.fails...)
+		PassReasons = append(PassReasons[:0], c.passes...)
+		opts.ShowPassed = c.showPassed
+
+		status, output := summarise(HttpResponse{
+			Elapsed:   250 * time.Millisecond,
+			URL:       "http://localhost/new",
+			Redirects: c.redirects,
+		})
+		expect(t, c.status, status)
+		expect(t, c.output, output)
+	}
+
+	FailReasons = FailReasons[:0]
+	PassReasons = PassReasons[:0]
+	opts.ShowPassed = false
+}
+
+func Test_summarise_Certificate(t *testing.T) {
+
+	ca, caKey := testCert("Test CA", time.Now().Add(365*24*time.Hour), nil, nil)
+	leaf, _ := testCert("localhost", time.Now().Add(45*24*time.Hour+time.Hour), ca, caKey)
+
+	FailReasons = FailReasons[:0]
+	PassReasons = append(PassReasons[:0], "Certificate checked")
+	Tests["certificate"] = true
+
+	_, output := summarise(HttpResponse{
+		Elapsed: 250 * time.Millisecond,
+		TLS:     &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}},
+	})
+	expect(t, true, strings.HasPrefix(output,
+		"All 1 test(s) passed in 0.250s: "+
+			"Certificate 'CN=localhost' issued by 'CN=Test CA' expires in 45 day(s) on "))
+
+	PassReasons = PassReasons[:0]
+	Tests["certificate"] = false
+}
+
+func Test_requestUrl(t *testing.T) {
+
+	cases := []TestRequestUrlCase{
+		{"", "localhost", 8080, false, "http://localhost:8080/", true},
+		{"", "localhost", 0, true, "https://localhost/", true},
+		{"https://api.local:8443/health", "", 0, false, "https://api.local:8443/health", true},
+		{"http://api.local/health", "", 0, true, "", false},
+		{"http://api.local/health", "other.local", 0, false, "", false},
+		{"http://api.local/health", "", 8080, false, "", false},
+	}
+
+	for _, c := range cases {
+		httpOpts.Url = c.url
+		httpOpts.Hostname = c.hostname
+		httpOpts.Port = c.port
+		httpOpts.Ssl = c.ssl
+
+		url, err := requestUrl()
+		expect(t, c.ok, err == nil)
+		expect(t, c.want, url)
+	}
+
+	httpOpts.Url = ""
+	httpOpts.Hostname = ""
+	httpOpts.Port = 0
+	httpOpts.Ssl = false
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path expressions address values inside a JSON document. Supported forms:
+//
+//	checks.db.status   nested object keys
+//	items[0].id        array index (negative indexes count from the end)
+//	items[*].state     every element of an array or value of an object
+//	["odd.key"]        quoted object key containing special characters
+//	..status           recursive descent, matches 'status' at any depth
+//	items.length       number of elements in an array
+//
+// The document itself is addressed by '$', so top-level arrays and scalars
+// can be checked with paths like $, $[0].id or $.length.
+//
+// Paths starting with '$' are JSONPath queries. These also allow:
+//
+//	$.items[0,2]                       unions of indexes or keys
+//	$.items[1:3]                       array slices
+//	$.components[?(@.name=='db')]      filter expressions
+type pathSegment struct {
+	key       string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+
+	// JSONPath only
+	union      []pathSegment
+	slice      bool
+	start, end *int
+	filter     filterExpr
+}
+
+// How many of the values addressed by a path must pass a test. Written
+// around the path, eg. all(items[*].state) or atleast(2, nodes[*].up).
+//
+//	any      at least one value passes (the default)
+//	all      every value passes
+//	none     no value passes
+//	atleast  at least n values pass
+type quantifier struct {
+	kind string
+	n    int
+}
+
+var quantifierRegexp = regexp.MustCompile(`^(any|all|none)\((.+)\)$`)
+var atleastRegexp = regexp.MustCompile(`^atleast\( *(\d+) *, *(.+)\)$`)
+
+// A value found in a JSON document along with its concrete location
+type pathValue struct {
+	loc   string
+	value interface{}
+}
+
+// Parse a path expression (eg. items[0].id) into segments
+func parsePath(str string) ([]pathSegment, error) {
+
+	if str == "" {
+		return nil, errors.New("Key path is blank")
+	}
+
+	// JSONPath queries are anchored at the document root with '$'
+	if strings.HasPrefix(str, "$") {
+		return parseSegments(str, 1, true)
+	}
+
+	return parseSegments(str, 0, false)
+}
+
+// Parse a JSON test key into its quantifier and path
+func parseKey(str string) (quantifier, []pathSegment, error) {
+
+	quant := quantifier{kind: "any"}
+
+	if m := quantifierRegexp.FindStringSubmatch(str); m != nil {
+		quant.kind = m[1]
+		str = m[2]
+	} else if m := atleastRegexp.FindStringSubmatch(str); m != nil {
+		quant.kind = "atleast"
+		quant.n, _ = strconv.Atoi(m[1])
+		str = m[2]
+	}
+
+	path, err := parsePath(strings.TrimSpace(str))
+	return quant, path, err
+}
+
+// Parse the segments of a path from str[i] onwards
+func parseSegments(str string, i int, query bool) ([]pathSegment, error) {
+
+	segs := make([]pathSegment, 0)
+	first := i
+
+	for i < len(str) {
+		recursive := false
+
+		switch {
+		case strings.HasPrefix(str[i:], ".."):
+			recursive = true
+			i += 2
+
+		case str[i] == '.':
+			if i == first && !query {
+				return nil, errors.New(fmt.Sprintf("Key path '%s' can not start with '.'", str))
+			}
+			i++
+
+		case str[i] == '[':
+			// Bracketed segments follow on directly
+
+		default:
+			if i != first || query {
+				return nil, errors.New(fmt.Sprintf("Key path '%s' has unexpected '%c'", str, str[i]))
+			}
+		}
+
+		if i >= len(str) {
+			return nil, errors.New(fmt.Sprintf("Key path '%s' can not end with '.'", str))
+		}
+
+		var seg pathSegment
+		var err error
+
+		if str[i] == '[' {
+			seg, i, err = parseBracket(str, i, query)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			end := strings.IndexAny(str[i:], ".[")
+			if end == -1 {
+				end = len(str) - i
+			}
+			name := str[i : i+end]
+			if name == "" {
+				return nil, errors.New(fmt.Sprintf("Key path '%s' has an empty key", str))
+			}
+			if name == "*" {
+				seg.wildcard = true
+			} else {
+				seg.key = name
+			}
+			i += end
+		}
+
+		seg.recursive = recursive
+		segs = append(segs, seg)
+	}
+
+	return segs, nil
+}
+
+// Parse a [...] segment starting at str[i]. Returns the index after the ']'
+func parseBracket(str string, i int, query bool) (pathSegment, int, error) {
+
+	var seg pathSegment
+
+	end := closingBracket(str, i)
+	if end == -1 {
+		return seg, 0, errors.New(fmt.Sprintf("Key path '%s' has an unclosed '['", str))
+	}
+	inner := strings.TrimSpace(str[i+1 : end])
+
+	if strings.HasPrefix(inner, "?") {
+		if !query {
+			return seg, 0, errors.New(fmt.Sprintf("Key path '%s' uses a filter, start it with '$'", str))
+		}
+		filter, err := parseFilter(inner[1:])
+		if err != nil {
+			return seg, 0, errors.New(fmt.Sprintf("Key path '%s' has an invalid filter: %s", str, err))
+		}
+		seg.filter = filter
+		return seg, end + 1, nil
+	}
+
+	if parts := splitOutside(inner, ','); len(parts) > 1 {
+		if !query {
+			return seg, 0, errors.New(fmt.Sprintf("Key path '%s' uses a union, start it with '$'", str))
+		}
+		for _, part := range parts {
+			member, err := parseSubscript(str, strings.TrimSpace(part))
+			if err != nil {
+				return seg, 0, err
+			}
+			seg.union = append(seg.union, member)
+		}
+		return seg, end + 1, nil
+	}
+
+	if parts := splitOutside(inner, ':'); len(parts) > 1 {
+		if !query {
+			return seg, 0, errors.New(fmt.Sprintf("Key path '%s' uses a slice, start it with '$'", str))
+		}
+		if len(parts) != 2 {
+			return seg, 0, errors.New(fmt.Sprintf("Key path '%s' has an invalid slice '%s'", str, inner))
+		}
+		seg.slice = true
+		for n, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(part)
+			if err != nil {
+				return seg, 0, errors.New(fmt.Sprintf("Key path '%s' has an invalid slice '%s'", str, inner))
+			}
+			if n == 0 {
+				seg.start = &idx
+			} else {
+				seg.end = &idx
+			}
+		}
+		return seg, end + 1, nil
+	}
+
+	seg, err := parseSubscript(str, inner)
+	return seg, end + 1, err
+}
+
+// Parse a single quoted key, index or '*' from inside brackets
+func parseSubscript(str, inner string) (pathSegment, error) {
+
+	var seg pathSegment
+
+	switch {
+	case inner == "*":
+		seg.wildcard = true
+
+	case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') &&
+		inner[len(inner)-1] == inner[0]:
+		seg.key = inner[1 : len(inner)-1]
+
+	case len(inner) > 0 && (inner[0] == '"' || inner[0] == '\''):
+		return seg, errors.New(fmt.Sprintf("Key path '%s' has an unclosed quoted key", str))
+
+	default:
+		idx, err := strconv.Atoi(inner)
+		if err != nil {
+			return seg, errors.New(fmt.Sprintf("Key path '%s' has an invalid index '%s'", str, inner))
+		}
+		seg.index = idx
+		seg.isIndex = true
+	}
+
+	return seg, nil
+}
+
+// Find the ']' matching the '[' at str[i], skipping quoted strings and
+// nested brackets. Returns -1 if there is none.
+func closingBracket(str string, i int) int {
+
+	depth := 0
+	var quote byte
+
+	for ; i < len(str); i++ {
+		c := str[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 {
+				if c != ']' {
+					return -1
+				}
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Split str on sep, ignoring separators inside quotes or brackets
+func splitOutside(str string, sep byte) []string {
+
+	parts := make([]string, 0)
+	depth := 0
+	last := 0
+	var quote byte
+
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, str[last:i])
+			last = i + 1
+		}
+	}
+
+	return append(parts, str[last:])
+}
+
+// Find every value in the JSON document addressed by the path
+func lookupPath(doc interface{}, path []pathSegment) []pathValue {
+
+	found := []pathValue{{"", doc}}
+
+	for _, seg := range path {
+		next := make([]pathValue, 0)
+
+		for _, pv := range found {
+			if seg.recursive {
+				for _, d := range descendants(pv) {
+					next = append(next, applySegment(d, seg)...)
+				}
+			} else {
+				next = append(next, applySegment(pv, seg)...)
+			}
+		}
+
+		found = next
+	}
+
+	return found
+}
+
+// Apply a single non-recursive path segment to a JSON value
+func applySegment(pv pathValue, seg pathSegment) []pathValue {
+
+	if seg.union != nil {
+		res := make([]pathValue, 0)
+		for _, member := range seg.union {
+			res = append(res, applySegment(pv, member)...)
+		}
+		return res
+	}
+
+	if seg.filter != nil {
+		res := make([]pathValue, 0)
+		for _, child := range applySegment(pv, pathSegment{wildcard: true}) {
+			if seg.filter.match(child.value) {
+				res = append(res, child)
+			}
+		}
+		return res
+	}
+
+	switch t := pv.value.(type) {
+
+	case []interface{}:
+		if seg.wildcard {
+			res := make([]pathValue, 0, len(t))
+			for i, v := range t {
+				res = append(res, pathValue{indexLoc(pv.loc, i), v})
+			}
+			return res
+		}
+
+		if seg.slice {
+			start, end := sliceBounds(seg, len(t))
+			res := make([]pathValue, 0)
+			for i := start; i < end; i++ {
+				res = append(res, pathValue{indexLoc(pv.loc, i), t[i]})
+			}
+			return res
+		}
+
+		// Arrays have no keys, so 'length' can't clash with one
+		if seg.key == "length" {
+			return []pathValue{{keyLoc(pv.loc, seg.key), json.Number(strconv.Itoa(len(t)))}}
+		}
+
+		if seg.isIndex {
+			idx := seg.index
+			if idx < 0 {
+				idx += len(t)
+			}
+			if 0 <= idx && idx < len(t) {
+				return []pathValue{{indexLoc(pv.loc, idx), t[idx]}}
+			}
+		}
+
+	case map[string]interface{}:
+		if seg.wildcard {
+			res := make([]pathValue, 0, len(t))
+			for _, k := range sortedKeys(t) {
+				res = append(res, pathValue{keyLoc(pv.loc, k), t[k]})
+			}
+			return res
+		}
+
+		if !seg.isIndex && !seg.slice {
+			if v, ok := t[seg.key]; ok {
+				return []pathValue{{keyLoc(pv.loc, seg.key), v}}
+			}
+		}
+
+	}
+
+	return nil
+}
+
+// Resolve the [start:end] of a slice against an array of the given length
+func sliceBounds(seg pathSegment, length int) (int, int) {
+
+	bound := func(idx *int, def int) int {
+		if idx == nil {
+			return def
+		}
+		i := *idx
+		if i < 0 {
+			i += length
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > length {
+			return length
+		}
+		return i
+	}
+
+	return bound(seg.start, 0), bound(seg.end, length)
+}
+
+// A value followed by every value nested below it, in document order
+func descendants(pv pathValue) []pathValue {
+
+	res := []pathValue{pv}
+
+	switch t := pv.value.(type) {
+	case []interface{}:
+		for i, v := range t {
+			res = append(res, descendants(pathValue{indexLoc(pv.loc, i), v})...)
+		}
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			res = append(res, descendants(pathValue{keyLoc(pv.loc, k), t[k]})...)
+		}
+	}
+
+	return res
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func keyLoc(loc, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%q]", loc, key)
+	}
+	if loc == "" {
+		return key
+	}
+	return loc + "." + key
+}
+
+func indexLoc(loc string, idx int) string {
+	return fmt.Sprintf("%s[%d]", loc, idx)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"testing"
+)
+
+/*
+ * Data models to hold test cases for key paths
+ */
+
+type TestParsePathCase struct {
+	path  string
+	segs  int
+	valid bool
+}
+
+type TestLookupPathCase struct {
+	jsonBlob []byte
+	path     string
+	locs     []string
+}
+
+type TestParseKeyCase struct {
+	key  string
+	kind string
+	n    int
+	segs int
+}
+
+/*
+ * Tests for primary functions
+ */
+
+func Test_parsePath(t *testing.T) {
+
+	cases := []TestParsePathCase{
+		{"status", 1, true},
+		{"checks.db.status", 3, true},
+		{"items[0].id", 3, true},
+		{"items[*].state", 3, true},
+		{"items.*.state", 3, true},
+		{"..status", 1, true},
+		{"checks..status", 2, true},
+		{"[0]", 1, true},
+		{`headers["Content-Type"]`, 2, true},
+		{`["a.b"].c`, 2, true},
+		{"", 0, false},
+		{".status", 0, false},
+		{"checks.", 0, false},
+		{"checks..", 0, false},
+		{"items[0", 0, false},
+		{"items[x]", 0, false},
+		{"items[0]id", 0, false},
+		{`items["id]`, 0, false},
+	}
+
+	for _, c := range cases {
+		segs, err := parsePath(c.path)
+		expect(t, c.valid, err == nil)
+		expect(t, c.segs, len(segs))
+	}
+}
+
+func Test_lookupPath(t *testing.T) {
+
+	doc := []byte(`{
+		"status": "ok",
+		"checks": {"db": {"status": "down"}, "cache": {"status": "up"}},
+		"items": [{"id": 1, "state": "a"}, {"id": 2, "state": "b"}],
+		"a.b": {"c": true}
+	}`)
+
+	cases := []TestLookupPathCase{
+		{doc, "status", []string{"status"}},
+		{doc, "checks.db.status", []string{"checks.db.status"}},
+		{doc, "items[1].id", []string{"items[1].id"}},
+		{doc, "items[-1].id", []string{"items[1].id"}},
+		{doc, "items[2].id", []string{}},
+		{doc, "items[*].state", []string{"items[0].state", "items[1].state"}},
+		{doc, "checks.*.status",
+			[]string{"checks.cache.status", "checks.db.status"}},
+		{doc, "..status",
+			[]string{"status", "checks.cache.status", "checks.db.status"}},
+		{doc, "checks..status",
+			[]string{"checks.cache.status", "checks.db.status"}},
+		{doc, `["a.b"].c`, []string{`["a.b"].c`}},
+		{doc, "status.missing", []string{}},
+		{[]byte(`[{"id": 7}]`), "[0].id", []string{"[0].id"}},
+		{[]byte(`[{"id": 7}]`), "$", []string{""}},
+		{[]byte(`[{"id": 7}]`), "$[0].id", []string{"[0].id"}},
+		{[]byte(`[{"id": 7}]`), "$.length", []string{"length"}},
+		{doc, "items.length", []string{"items.length"}},
+		{[]byte(`42`), "$", []string{""}},
+		{[]byte(`42`), "$.length", []string{}},
+	}
+
+	for _, c := range cases {
+		jsonDoc, err := decodeJson(c.jsonBlob)
+		check(err)
+
+		path, err := parsePath(c.path)
+		check(err)
+
+		found := lookupPath(jsonDoc, path)
+		expect(t, len(c.locs), len(found))
+
+		for i := 0; i < len(c.locs) && i < len(found); i++ {
+			expect(t, c.locs[i], found[i].loc)
+		}
+	}
+}
+
+func Test_lookupQuery(t *testing.T) {
+
+	doc := []byte(`{
+		"components": [
+			{"name": "db", "status": "up", "latency": 12, "primary": true},
+			{"name": "cache", "status": "down", "latency": 120},
+			{"name": "queue", "status": "up", "latency": 45, "primary": false}
+		],
+		"meta": {"region": "us-east"}
+	}`)
+
+	cases := []TestLookupPathCase{
+		{doc, "$", []string{""}},
+		{doc, "$.meta.region", []string{"meta.region"}},
+		{doc, "$['meta']['region']", []string{"meta.region"}},
+		{doc, "$.components[?(@.name=='db')].status",
+			[]string{"components[0].status"}},
+		{doc, `$.components[?(@.name == "cache")].status`,
+			[]string{"components[1].status"}},
+		{doc, "$.components[?(@.latency > 40)].name",
+			[]string{"components[1].name", "components[2].name"}},
+		{doc, "$.components[?(@.latency <= 45 && @.status != 'up')].name",
+			[]string{}},
+		{doc, "$.components[?(@.status == 'down' || @.latency >= 45)].name",
+			[]string{"components[1].name", "components[2].name"}},
+		{doc, "$.components[?(@.primary)].name",
+			[]string{"components[0].name", "components[2].name"}},
+		{doc, "$.components[?(!@.primary)].name",
+			[]string{"components[1].name"}},
+		{doc, "$.components[?(@.primary == true)].name",
+			[]string{"components[0].name"}},
+		{doc, "$.components[?(@.latency == 12.0)].name",
+			[]string{"components[0].name"}},
+		{[]byte(`[{"seq": 9007199254740993}, {"seq": 9007199254740992}]`),
+			"$[?(@.seq > 9007199254740992)]", []string{"[0]"}},
+		{doc, "$.components[?(@.name =~ /^c/)].name",
+			[]string{"components[1].name"}},
+		{doc, "$.components[?(@.name =~ 'ue$')].name",
+			[]string{"components[2].name"}},
+		{doc, "$.components[0,2].name",
+			[]string{"components[0].name", "components[2].name"}},
+		{doc, "$.components[1:].name",
+			[]string{"components[1].name", "components[2].name"}},
+		{doc, "$.components[:-1].name",
+			[]string{"components[0].name", "components[1].name"}},
+		{doc, "$..region", []string{"meta.region"}},
+		{doc, "$..[?(@.status=='up')].name",
+			[]string{"components[0].name", "components[2].name"}},
+	}
+
+	for _, c := range cases {
+		jsonDoc, err := decodeJson(c.jsonBlob)
+		check(err)
+
+		path, err := parsePath(c.path)
+		check(err)
+
+		found := lookupPath(jsonDoc, path)
+		expect(t, len(c.locs), len(found))
+
+		for i := 0; i < len(c.locs) && i < len(found); i++ {
+			expect(t, c.locs[i], found[i].loc)
+		}
+	}
+}
+
+func Test_parseQuery_Errors(t *testing.T) {
+
+	cases := []string{
+		"$name",
+		"$.items[?(@.name=='db']",
+		"$.items[?(@.name==)]",
+		"$.items[?(@.name=~/db)]",
+		"$.items[?(@.name=~'[')]",
+		"$.items[?(1)]",
+		"$.items[1:2:3]",
+		"items[?(@.name=='db')]",
+		"items[0,1]",
+		"items[1:]",
+	}
+
+	for _, c := range cases {
+		_, err := parsePath(c)
+		expectErr(t, err)
+	}
+}
+
+func Test_parseKey(t *testing.T) {
+
+	cases := []TestParseKeyCase{
+		{"items[*].state", "any", 0, 3},
+		{"any(items[*].state)", "any", 0, 3},
+		{"all(items[*].state)", "all", 0, 3},
+		{"none($.items[?(@.up)].id)", "none", 0, 3},
+		{"atleast(3, nodes[*].up)", "atleast", 3, 3},
+		{"atleast(0,nodes)", "atleast", 0, 1},
+	}
+
+	for _, c := range cases {
+		quant, path, err := parseKey(c.key)
+		check(err)
+		expect(t, c.kind, quant.kind)
+		expect(t, c.n, quant.n)
+		expect(t, c.segs, len(path))
+	}
+
+	_, _, err := parseKey("all( )")
+	expectErr(t, err)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+type PerfdataOptions struct {
+	PerfdataKey func(string) `long:"perfdata-key" description:"Publish a numeric JSON value as performance data (path[:uom], eg. queue.depth or latency:ms)"`
+}
+
+var perfOpts PerfdataOptions
+
+// A JSON value to publish as performance data
+type PerfKey struct {
+	path string
+	uom  string
+}
+
+// JSON values to publish as performance data. Driven by flags.
+var PerfKeys = make([]PerfKey, 0)
+
+// Units of measurement allowed by the Nagios plugin guidelines
+var validUoms = map[string]bool{
+	"": true, "s": true, "ms": true, "us": true, "%": true,
+	"B": true, "KB": true, "MB": true, "TB": true, "c": true,
+}
+
+func init() {
+
+	perfOpts.PerfdataKey = func(str string) {
+		path, uom := str, ""
+
+		// The unit is optional so only split if there is one
+		if s, err := parseFlagPair("perfdata-key", str); err == nil {
+			path, uom = s[0], s[1]
+		}
+		validatePath(path)
+
+		if !validUoms[uom] {
+			nagiosplugin.Exit(
+				nagiosplugin.UNKNOWN,
+				fmt.Sprintf("Perfdata unit '%s' is not a valid Nagios unit", uom),
+			)
+		}
+
+		PerfKeys = append(PerfKeys, PerfKey{path, uom})
+	}
+
+	parser.AddGroup("Performance Data Options", "Perfdata", &perfOpts)
+}
+
+// Add performance data describing the HTTP response
+func responsePerfData(nagiosCheck *nagiosplugin.Check, resp HttpResponse) {
+
+	var warn, crit *float64
+	if v, ok := TimeTest["warning"]; ok {
+		warn = &v
+	}
+	if v, ok := TimeTest["critical"]; ok {
+		crit = &v
+	}
+
+	err := nagiosCheck.AddPerfDatum("time", "s", resp.Elapsed.Seconds(),
+		thresholdList(warn, crit)...)
+	check(err)
+
+	err = nagiosCheck.AddPerfDatum("size", "B", float64(len(resp.Body)))
+	check(err)
+
+	// Documents from files and commands have no status
+	if resp.Status != 0 {
+		err = nagiosCheck.AddPerfDatum("status", "", float64(resp.Status))
+		check(err)
+	}
+}
+
+// Add the days until the server certificate chain expires, for --certificate.
+// Its thresholds are minimums, which a plain perfdata threshold can't
+// express, so they are left out.
+func certificatePerfData(nagiosCheck *nagiosplugin.Check, state *tls.ConnectionState, now time.Time) {
+
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return // Reported by the test
+	}
+
+	days := daysLeft(soonestExpiry(state.PeerCertificates), now)
+	err := nagiosCheck.AddPerfDatum("certificate_days", "", float64(days))
+	check(err)
+}
+
+// Add performance data for the numeric JSON values selected by flags
+func jsonPerfData(nagiosCheck *nagiosplugin.Check, doc interface{}) {
+
+	for _, pk := range PerfKeys {
+		thresholds := perfThresholds(pk.path, "")
+
+		_, path, err := parseKey(pk.path)
+		check(err)
+
+		// Wildcards publish a value for each match, labelled by location
+		for _, pv := range lookupPath(doc, path) {
+			n, ok := pv.value.(json.Number)
+			if !ok {
+				continue // Only numbers can be graphed
+			}
+			v, err := n.Float64()
+			if err != nil {
+				continue
+			}
+
+			label := perfLabel(pv.loc, pk.path)
+
+			err = nagiosCheck.AddPerfDatum(label, pk.uom, v, thresholds...)
+			check(err)
+		}
+	}
+}
+
+// Add performance data for the age of the timestamps tested by --key-age
+func agePerfData(nagiosCheck *nagiosplugin.Check, doc interface{}, now time.Time) {
+
+	for _, tst := range JsonTests {
+		if tst.operator != "age" {
+			continue
+		}
+		ages := tst.value.(AgeThresholds)
+
+		_, path, err := parseKey(tst.key)
+		check(err)
+
+		for _, pv := range lookupPath(doc, path) {
+			t, err := parseTimestamp(pv.value, opts.KeyAgeLayout)
+			if err != nil {
+				continue // Reported by the test
+			}
+
+			label := perfLabel(pv.loc, tst.key)
+
+			err = nagiosCheck.AddPerfDatum(label+"_age", "s", now.Sub(t).Seconds(),
+				ageThresholds(ages)...)
+			check(err)
+		}
+	}
+}
+
+// Add performance data for the lengths tested by --key-length and its
+// threshold flags, once for each key path
+func lengthPerfData(nagiosCheck *nagiosplugin.Check, doc interface{}) {
+
+	seen := make(map[string]bool)
+
+	for _, tst := range JsonTests {
+		if !strings.HasPrefix(strings.TrimPrefix(tst.operator, "!"), "length-") || seen[tst.key] {
+			continue
+		}
+		seen[tst.key] = true
+
+		thresholds := perfThresholds(tst.key, "length-")
+
+		_, path, err := parseKey(tst.key)
+		check(err)
+
+		for _, pv := range lookupPath(doc, path) {
+			n, ok := jsonLength(pv.value)
+			if !ok {
+				continue // Reported by the test
+			}
+
+			label := perfLabel(pv.loc, tst.key)
+
+			err = nagiosCheck.AddPerfDatum(label+"_length", "", float64(n), thresholds...)
+			check(err)
+		}
+	}
+}
+
+// Characters that would break the label=value perfdata format
+var perfLabelReplacer = strings.NewReplacer(" ", "_", "\t", "_", "=", "_", "'", "_", "|", "_")
+
+// Label perfdata by the location of its value, or the key path given when
+// the location is the document itself
+func perfLabel(loc, key string) string {
+	if loc == "" {
+		loc = key
+	}
+	return perfLabelReplacer.Replace(loc)
+}
+
+// Warning and critical thresholds set for a JSON key by --key-warning and
+// --key-critical, or other tests with a prefix (eg. --key-length-warning).
+// Perfdata thresholds are single values so only ranges with a plain upper
+// bound (eg. 100) are used.
+func perfThresholds(key string, prefix string) []float64 {
+
+	var warn, crit *float64
+
+	for _, tst := range JsonTests {
+		if tst.key != key {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(fmt.Sprintf("%v", tst.value), 64)
+		if err != nil {
+			continue
+		}
+
+		switch tst.operator {
+		case prefix + "warning":
+			warn = &v
+		case prefix + "critical":
+			crit = &v
+		}
+	}
+
+	return thresholdList(warn, crit)
+}
+
+// Perfdata thresholds for the --key-age limits, in seconds. A zero limit
+// isn't checked so isn't published.
+func ageThresholds(ages AgeThresholds) []float64 {
+
+	var warn, crit *float64
+	if ages.warning > 0 {
+		w := ages.warning.Seconds()
+		warn = &w
+	}
+	if ages.critical > 0 {
+		c := ages.critical.Seconds()
+		crit = &c
+	}
+
+	return thresholdList(warn, crit)
+}
+
+// AddPerfDatum takes positional min, max, warn and crit thresholds. NaN
+// ones are left out of the output, so use them for anything not set.
+func thresholdList(warn, crit *float64) []float64 {
+
+	if warn == nil && crit == nil {
+		return nil
+	}
+
+	thresholds := []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+	if warn != nil {
+		thresholds[2] = *warn
+	}
+	if crit != nil {
+		thresholds[3] = *crit
+	}
+
+	return thresholds
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fractalcat/nagiosplugin"
+)
+
+/*
+ * Data models to hold perfdata test cases
+ */
+
+type TestPerfKeyCase struct {
+	param string
+	path  string
+	uom   string
+}
+
+type TestJsonPerfDataCase struct {
+	param    string
+	send     []byte
+	perfdata string
+}
+
+type TestResponsePerfDataCase struct {
+	warning  float64
+	critical float64
+	perfdata string
+}
+
+type TestPerfThresholdsCase struct {
+	warning  string
+	critical string
+	perfdata string
+}
+
+/*
+ * Tests for primary functions
+ */
+
+func Test_perfdataKey(t *testing.T) {
+
+	cases := []TestPerfKeyCase{
+		{"queue.depth", "queue.depth", ""},
+		{"latency:ms", "latency", "ms"},
+		{"$.items[1:]", "$.items[1:]", ""},
+		{"$.items[1:].size:KB", "$.items[1:].size", "KB"},
+	}
+
+	for _, c := range cases {
+		PerfKeys = PerfKeys[:0]
+
+		// eg. perfOpts.PerfdataKey("latency:ms") simulates --perfdata-key=latency:ms
+		perfOpts.PerfdataKey(c.param)
+
+		expect(t, 1, len(PerfKeys))
+		expect(t, c.path, PerfKeys[0].path)
+		expect(t, c.uom, PerfKeys[0].uom)
+	}
+
+	PerfKeys = PerfKeys[:0]
+}
+
+func Test_perfThresholds(t *testing.T) {
+
+	cases := []TestPerfThresholdsCase{
+		{"", "", "queue.depth=42;;;;"},
+		{"100", "", "queue.depth=42;100;;;"},
+		{"100", "500", "queue.depth=42;100;500;;"},
+		{"", "500", "queue.depth=42;;500;;"},
+		{"10:", "500", "queue.depth=42;;500;;"},
+		{"100", "@400:500", "queue.depth=42;100;;;"},
+	}
+
+	for _, c := range cases {
+		JsonTests = JsonTests[:0]
+
+		if c.warning != "" {
+			opts.FlagKeyWarning("queue.depth:" + c.warning)
+		}
+		if c.critical != "" {
+			opts.FlagKeyCritical("queue.depth:" + c.critical)
+		}
+
+		expect(t, c.perfdata, renderPerfDatum("queue.depth", 42, perfThresholds("queue.depth", "")))
+	}
+
+	// Length thresholds are kept apart from value thresholds
+	JsonTests = JsonTests[:0]
+	opts.FlagKeyWarning("nodes:100")
+	opts.FlagKeyLengthWarning("nodes:5")
+	opts.FlagKeyLengthCritical("nodes:10")
+
+	expect(t, "nodes=3;100;;;", renderPerfDatum("nodes", 3, perfThresholds("nodes", "")))
+	expect(t, "nodes=3;5;10;;", renderPerfDatum("nodes", 3, perfThresholds("nodes", "length-")))
+
+	JsonTests = JsonTests[:0]
+}
+
+func Test_responsePerfData(t *testing.T) {
+
+	cases := []TestResponsePerfDataCase{
+		{0, 0, "time=1.5s;;;; size=2B;;;; status=200;;;;"},
+		{1, 0, "time=1.5s;1;;; size=2B;;;; status=200;;;;"},
+		{0, 2, "time=1.5s;;2;; size=2B;;;; status=200;;;;"},
+		{1, 2, "time=1.5s;1;2;; size=2B;;;; status=200;;;;"},
+	}
+
+	resp := HttpResponse{Status: 200, Body: []byte("{}"), Elapsed: 1500 * time.Millisecond}
+
+	for _, c := range cases {
+		delete(TimeTest, "warning")
+		delete(TimeTest, "critical")
+
+		// eg. opts.FlagCritical(2) simulates -c 2
+		if c.warning != 0 {
+			opts.FlagWarning(c.warning)
+		}
+		if c.critical != 0 {
+			opts.FlagCritical(c.critical)
+		}
+
+		nagiosCheck := nagiosplugin.NewCheck()
+		responsePerfData(nagiosCheck, resp)
+
+		expect(t, c.perfdata, checkPerfData(nagiosCheck))
+	}
+
+	delete(TimeTest, "warning")
+	delete(TimeTest, "critical")
+	Tests["time"] = false
+}
+
+func Test_certificatePerfData(t *testing.T) {
+
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	ca, caKey := testCert("Test CA", now.Add(20*24*time.Hour), nil, nil)
+	leaf, _ := testCert("localhost", now.Add(45*24*time.Hour), ca, caKey)
+
+	// The chain expires with its CA
+	nagiosCheck := nagiosplugin.NewCheck()
+	certificatePerfData(nagiosCheck,
+		&tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}}, now)
+	expect(t, "certificate_days=20;;;;", checkPerfData(nagiosCheck))
+
+	// Nothing to publish without TLS
+	nagiosCheck = nagiosplugin.NewCheck()
+	certificatePerfData(nagiosCheck, nil, now)
+	expect(t, false, strings.Contains(nagiosCheck.String(), "certificate_days"))
+}
+
+func Test_jsonPerfData(t *testing.T) {
+
+	cases := []TestJsonPerfDataCase{
+		{"queue.depth", []byte(`{"queue":{"depth":5}}`), "queue.depth=5;;;;"},
+		{"$", []byte(`5`), "$=5;;;;"},
+		{"items[*].size:KB", []byte(`{"items":[{"size":1},{"size":2}]}`),
+			"items[0].size=1KB;;;; items[1].size=2KB;;;;"},
+
+		// Labels can't contain spaces, quotes or '='
+		{`["queue depth"]`, []byte(`{"queue depth":5}`), "queue_depth=5;;;;"},
+		{`["a=b"]`, []byte(`{"a=b":5}`), "a_b=5;;;;"},
+		{`["it's"]`, []byte(`{"it's":5}`), "it_s=5;;;;"},
+	}
+
+	for _, c := range cases {
+		PerfKeys = PerfKeys[:0]
+		perfOpts.PerfdataKey(c.param)
+
+		doc, err := decodeJson(c.send)
+		check(err)
+
+		nagiosCheck := nagiosplugin.NewCheck()
+		jsonPerfData(nagiosCheck, doc)
+		expect(t, c.perfdata, checkPerfData(nagiosCheck))
+	}
+
+	PerfKeys = PerfKeys[:0]
+}
+
+// The perfdata a check outputs for a single value
+func renderPerfDatum(label string, value float64, thresholds []float64) string {
+
+	nagiosCheck := nagiosplugin.NewCheck()
+	err := nagiosCheck.AddPerfDatum(label, "", value, thresholds...)
+	check(err)
+
+	return checkPerfData(nagiosCheck)
+}
+
+// The perfdata part of a check's output, after the '|'
+func checkPerfData(nagiosCheck *nagiosplugin.Check) string {
+	output := nagiosCheck.String()
+	return output[strings.Index(output, "|")+2:]
+}
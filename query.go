@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// JSONPath filter expressions, eg. [?(@.name=='db' && @.up==true)].
+//
+// Operands are relative paths from the current element ('@', '@.name',
+// '@[0]') or literals (numbers, 'strings', "strings", true, false, null).
+// Supported operators are ==, !=, <, <=, >, >=, =~ (regex match, with the
+// pattern as a string or /pattern/), &&, || and !. A lone relative path
+// tests for existence.
+type filterExpr interface {
+	match(v interface{}) bool
+}
+
+type filterAnd struct{ left, right filterExpr }
+
+type filterOr struct{ left, right filterExpr }
+
+type filterNot struct{ expr filterExpr }
+
+type filterExists struct{ path []pathSegment }
+
+type filterCompare struct {
+	op          string
+	left, right filterOperand
+	re          *regexp.Regexp // Compiled pattern for =~
+}
+
+type filterOperand struct {
+	path    []pathSegment
+	isPath  bool
+	literal interface{}
+}
+
+func (f filterAnd) match(v interface{}) bool { return f.left.match(v) && f.right.match(v) }
+
+func (f filterOr) match(v interface{}) bool { return f.left.match(v) || f.right.match(v) }
+
+func (f filterNot) match(v interface{}) bool { return !f.expr.match(v) }
+
+func (f filterExists) match(v interface{}) bool {
+	return len(lookupPath(v, f.path)) != 0
+}
+
+func (f filterCompare) match(v interface{}) bool {
+
+	left, ok := f.left.resolve(v)
+	if !ok {
+		return false
+	}
+
+	if f.op == "=~" {
+		s, ok := left.(string)
+		return ok && f.re.MatchString(s)
+	}
+
+	right, ok := f.right.resolve(v)
+	if !ok {
+		return false
+	}
+
+	return compareValues(f.op, left, right)
+}
+
+// The value of an operand for the current element. A path operand that
+// addresses nothing has no value.
+func (o filterOperand) resolve(v interface{}) (interface{}, bool) {
+
+	if !o.isPath {
+		return o.literal, true
+	}
+
+	found := lookupPath(v, o.path)
+	if len(found) == 0 {
+		return nil, false
+	}
+
+	return found[0].value, true
+}
+
+// Compare two JSON values. Ordering only applies to numbers and strings,
+// values of different types are never equal.
+func compareValues(op string, a, b interface{}) bool {
+
+	var cmp int
+
+	switch x := a.(type) {
+
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return op == "!="
+		}
+		if cmp, ok = compareNumbers(x, y); !ok {
+			return false
+		}
+
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return op == "!="
+		}
+		cmp = strings.Compare(x, y)
+
+	case bool, nil:
+		// Booleans and null only support (in)equality
+		equal := a == b
+		switch op {
+		case "==":
+			return equal
+		case "!=":
+			return !equal
+		}
+		return false
+
+	default:
+		// Objects and arrays can't be compared
+		return false
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+/*
+ * Filter expression parser
+ */
+
+type filterParser struct {
+	str string
+	pos int
+}
+
+// Parse the expression following the '?' in a filter segment
+func parseFilter(str string) (filterExpr, error) {
+
+	p := &filterParser{str: str}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.str) {
+		return nil, errors.New(fmt.Sprintf("unexpected '%s'", p.str[p.pos:]))
+	}
+
+	return expr, nil
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.str) && p.str[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// Consume tok if it is next in the input
+func (p *filterParser) accept(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.str[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+
+	if p.accept("!") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{expr}, nil
+	}
+
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.New("missing ')'")
+		}
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// Longer operators first so '<=' isn't read as '<'
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+
+		if op == "=~" {
+			return p.parseRegexp(left)
+		}
+
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return filterCompare{op: op, left: left, right: right}, nil
+	}
+
+	if !left.isPath {
+		return nil, errors.New("a literal on its own is not a test")
+	}
+
+	return filterExists{left.path}, nil
+}
+
+// Parse the pattern on the right hand side of =~
+func (p *filterParser) parseRegexp(left filterOperand) (filterExpr, error) {
+
+	var pattern string
+
+	p.skipSpace()
+	if p.pos < len(p.str) && p.str[p.pos] == '/' {
+		end := strings.IndexByte(p.str[p.pos+1:], '/')
+		if end == -1 {
+			return nil, errors.New("unclosed regexp")
+		}
+		pattern = p.str[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else {
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		s, ok := right.literal.(string)
+		if right.isPath || !ok {
+			return nil, errors.New("=~ needs a string or /regexp/")
+		}
+		pattern = s
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterCompare{op: "=~", left: left, re: re}, nil
+}
+
+func (p *filterParser) parseOperand() (filterOperand, error) {
+
+	var o filterOperand
+
+	p.skipSpace()
+	if p.pos >= len(p.str) {
+		return o, errors.New("missing operand")
+	}
+
+	rest := p.str[p.pos:]
+
+	switch {
+
+	// Relative path from the current element
+	case rest[0] == '@':
+		end := 1
+		depth := 0
+		var quote byte
+		for ; end < len(rest); end++ {
+			c := rest[end]
+			if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+			} else if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+			} else if depth == 0 && strings.IndexByte(" =!<>&|)", c) != -1 {
+				break
+			}
+		}
+
+		path, err := parseSegments(rest[:end], 1, true)
+		if err != nil {
+			return o, err
+		}
+		p.pos += end
+		o.path = path
+		o.isPath = true
+
+	case rest[0] == '\'' || rest[0] == '"':
+		end := strings.IndexByte(rest[1:], rest[0])
+		if end == -1 {
+			return o, errors.New("unclosed string")
+		}
+		o.literal = rest[1 : end+1]
+		p.pos += end + 2
+
+	default:
+		end := strings.IndexAny(rest, " =!<>&|)")
+		if end == -1 {
+			end = len(rest)
+		}
+		word := rest[:end]
+
+		switch word {
+		case "true":
+			o.literal = true
+		case "false":
+			o.literal = false
+		case "null":
+			o.literal = nil
+		default:
+			num, err := parseNumber(word)
+			if err != nil {
+				return o, errors.New(fmt.Sprintf("unknown operand '%s'", word))
+			}
+			o.literal = num
+		}
+		p.pos += end
+	}
+
+	return o, nil
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/fractalcat/nagiosplugin"
 )
 
 // Struct to hold tests of JSON response (key exists, key equals, etc)
@@ -21,6 +26,82 @@ type JsonTest struct {
 	operator string
 }
 
+// Describe the test for the plugin output
+func (tst JsonTest) String() string {
+
+	if strings.HasPrefix(tst.operator, "!") {
+		return fmt.Sprintf("Key '%s' %s", tst.key, tst.negatedPhrase())
+	}
+
+	return fmt.Sprintf("Key '%s' %s", tst.key, tst.phrase())
+}
+
+// What a value passing the test is, eg. "is at most '10'"
+func (tst JsonTest) phrase() string {
+
+	switch tst.operator {
+	case "exists":
+		return "exists"
+	case "equals":
+		return fmt.Sprintf("matches '%v'", tst.value)
+	case "is":
+		return fmt.Sprintf("is '%v'", tst.value)
+	case "is-nocase":
+		return fmt.Sprintf("is '%v' ignoring case", tst.value)
+	case "in":
+		return fmt.Sprintf("is one of %s", quoteList(tst.value.([]string)))
+	case "in-nocase":
+		return fmt.Sprintf("is one of %s ignoring case", quoteList(tst.value.([]string)))
+	case "type":
+		return fmt.Sprintf("has type '%v'", tst.value)
+	case "lte":
+		return fmt.Sprintf("is at most '%v'", tst.value)
+	case "gte":
+		return fmt.Sprintf("is at least '%v'", tst.value)
+	case "warning", "critical":
+		return fmt.Sprintf("is within %s threshold '%v'", tst.operator, tst.value)
+	case "length-warning", "length-critical":
+		return fmt.Sprintf("length is within %s threshold '%v'", strings.TrimPrefix(tst.operator, "length-"), tst.value)
+	case "age":
+		return fmt.Sprintf("is newer than '%v'", tst.value)
+	}
+
+	return fmt.Sprintf("%s '%v'", tst.operator, tst.value)
+}
+
+// What a value passing a negated test is, eg. "does not match 'down'"
+func (tst JsonTest) negatedPhrase() string {
+
+	switch tst.operator {
+	case "!exists":
+		return "is absent"
+	case "!equals":
+		return fmt.Sprintf("does not match '%v'", tst.value)
+	case "!is":
+		return fmt.Sprintf("is not '%v'", tst.value)
+	case "!is-nocase":
+		return fmt.Sprintf("is not '%v' ignoring case", tst.value)
+	case "!in":
+		return fmt.Sprintf("is not one of %s", quoteList(tst.value.([]string)))
+	case "!in-nocase":
+		return fmt.Sprintf("is not one of %s ignoring case", quoteList(tst.value.([]string)))
+	case "!type":
+		return fmt.Sprintf("does not have type '%v'", tst.value)
+	case "!lte":
+		return fmt.Sprintf("is more than '%v'", tst.value)
+	case "!gte":
+		return fmt.Sprintf("is less than '%v'", tst.value)
+	case "!warning", "!critical":
+		return fmt.Sprintf("is outside %s threshold '%v'", tst.operator[1:], tst.value)
+	case "!length-warning", "!length-critical":
+		return fmt.Sprintf("length is outside %s threshold '%v'", strings.TrimPrefix(tst.operator, "!length-"), tst.value)
+	case "!age":
+		return fmt.Sprintf("is older than '%v'", tst.value)
+	}
+
+	return fmt.Sprintf("%s '%v'", tst.operator, tst.value)
+}
+
 var flagSeperator = ":"
 var flagPairRegexp = regexp.MustCompile(".+" + flagSeperator + ".+")
 
@@ -28,12 +109,46 @@ var flagPairRegexp = regexp.MustCompile(".+" + flagSeperator + ".+")
  * Generic helper functions
  */
 
+// Panic on errors that should never happen. The Nagios check recovers
+// and reports these as CRITICAL.
 func check(e error) {
 	if e != nil {
 		panic(e)
 	}
 }
 
+// Exit UNKNOWN on invalid arguments, such as badly formatted flags or
+// missing files
+func checkArg(e error) {
+	if e != nil {
+		nagiosplugin.Exit(nagiosplugin.UNKNOWN, oneLine(e.Error()))
+	}
+}
+
+// Exit UNKNOWN if the response can't be understood (eg. invalid JSON)
+func checkResponse(e error) {
+	if e != nil {
+		nagiosplugin.Exit(
+			nagiosplugin.UNKNOWN,
+			oneLine(fmt.Sprintf("Unable to parse response: %s", e)),
+		)
+	}
+}
+
+// Plugin output must fit on the first line
+func oneLine(str string) string {
+	return strings.Join(strings.Fields(str), " ")
+}
+
+// Quote a list of values for messages, eg. 'a', 'b', 'c'
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("'%s'", v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
 func parseFlagPair(flagName, flagValue string) ([]string, error) {
 
 	match := flagPairRegexp.MatchString(flagValue)
@@ -43,7 +158,51 @@ func parseFlagPair(flagName, flagValue string) ([]string, error) {
 		)
 	}
 
-	return strings.Split(flagValue, flagSeperator), nil
+	// Split on the first separator, skipping any inside the brackets of a
+	// JSONPath query (eg. $.items[1:]). Values may contain the separator.
+	depth := 0
+	var quote byte
+	for i := 0; i < len(flagValue); i++ {
+		c := flagValue[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case depth > 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(flagValue[i:], flagSeperator):
+			return []string{flagValue[:i], flagValue[i+len(flagSeperator):]}, nil
+		}
+	}
+
+	return nil, errors.New(
+		fmt.Sprintf("Flag %s needs to be in 'key:value' format", flagName),
+	)
+}
+
+// Parse a Nagios state given as a flag, by name (eg. critical) or number
+func parseState(str string) (nagiosplugin.Status, error) {
+
+	switch strings.ToLower(str) {
+	case "ok", "0":
+		return nagiosplugin.OK, nil
+	case "warning", "1":
+		return nagiosplugin.WARNING, nil
+	case "critical", "2":
+		return nagiosplugin.CRITICAL, nil
+	case "unknown", "3":
+		return nagiosplugin.UNKNOWN, nil
+	}
+
+	return nagiosplugin.UNKNOWN, errors.New(
+		fmt.Sprintf("State '%s' must be one of ok, warning, critical or unknown", str),
+	)
 }
 
 /*
@@ -91,7 +250,7 @@ func httpServer(code int, hdrs http.Header, body string) *httptest.Server {
 
 func readFile(f string) io.Reader {
 	file, err := os.Open(f)
-	check(err)
+	checkArg(err)
 	return bufio.NewReader(file)
 }
 
@@ -99,6 +258,37 @@ func readStdIn() io.Reader {
 	return bufio.NewReader(os.Stdin)
 }
 
+/*
+ * Number helpers
+ */
+
+// Parse a number given as a flag, keeping the literal so it compares
+// exactly against JSON values
+func parseNumber(str string) (json.Number, error) {
+	if _, err := strconv.ParseFloat(str, 64); err != nil {
+		return "", errors.New(fmt.Sprintf("'%s' is not a number", str))
+	}
+	if _, ok := new(big.Rat).SetString(str); !ok {
+		return "", errors.New(fmt.Sprintf("'%s' is not a number", str))
+	}
+	return json.Number(str), nil
+}
+
+// Compare two numbers exactly, so large integers and decimals don't lose
+// precision to float64. Returns -1, 0 or 1, and false if either isn't a
+// number.
+func compareNumbers(a, b json.Number) (int, bool) {
+	x, ok := new(big.Rat).SetString(a.String())
+	if !ok {
+		return 0, false
+	}
+	y, ok := new(big.Rat).SetString(b.String())
+	if !ok {
+		return 0, false
+	}
+	return x.Cmp(y), true
+}
+
 /*
  * Byte / stream helpers
  */
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -24,6 +25,28 @@ func templateHndlr(str string) string {
 	return str
 }
 
+// Handle {{ templates }} found in the parameters of a URL query string.
+// Expanded keys and values are escaped, the rest are left as given.
+func expandQuery(rawQuery string) string {
+
+	if !templateTest(rawQuery) {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		for j, part := range parts {
+			if templateTest(part) {
+				parts[j] = url.QueryEscape(templateHndlr(part))
+			}
+		}
+		params[i] = strings.Join(parts, "=")
+	}
+
+	return strings.Join(params, "&")
+}
+
 // Handle individual {{ template }} commands
 func templateCmd(templateTxt string) string {
 	params := strings.Split(templateTxt, " ")
@@ -21,6 +21,11 @@ type TestTemplateCmdCase struct {
 	expect string
 }
 
+type TestExpandQueryCase struct {
+	query  string
+	expect string
+}
+
 /*
  * Tests for primary functions
  */
@@ -77,3 +82,24 @@ func Test_templateCmd(t *testing.T) {
 	err = os.Setenv(envKey, "")
 	check(err)
 }
+
+func Test_expandQuery(t *testing.T) {
+
+	envKey := "CHECKJSONENV"
+	err := os.Setenv(envKey, "a&b=c d")
+	check(err)
+
+	cases := []TestExpandQueryCase{
+		{"x=1&y=%2F", "x=1&y=%2F"},
+		{"q={{ env CHECKJSONENV }}", "q=a%26b%3Dc+d"},
+		{"{{env CHECKJSONENV}}=1&flag", "a%26b%3Dc+d=1&flag"},
+		{"date={{ isotime 2006 }}&x=y", fmt.Sprintf("date=%s&x=y", time.Now().Format("2006"))},
+	}
+
+	for _, c := range cases {
+		expect(t, c.expect, expandQuery(c.query))
+	}
+
+	err = os.Setenv(envKey, "")
+	check(err)
+}